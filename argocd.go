@@ -2,46 +2,112 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
 type ArgoCDClient struct {
-	baseURL string
-	token   string
-	client  *http.Client
+	baseURL     string
+	token       string
+	catalog     *Catalog
+	client      *http.Client
+	retry       RetryPolicy
+	breaker     *CircuitBreaker
+	callTimeout time.Duration
+	settings    ApplicationSettings
 }
 
+// ApplicationSettings controls where Applications are created, where they
+// deploy to, the URL environments are served at, the profiles that shape
+// them and the image that generates dependency credentials.
+type ApplicationSettings struct {
+	Project           string
+	Namespace         string
+	DestinationServer string
+	DefaultProfile    string
+	Profiles          []Profile
+	Ingress           IngressConfig
+	CredentialsImage  string
+}
+
+func DefaultApplicationSettings() ApplicationSettings {
+	return ApplicationSettings{
+		Project:           "default",
+		Namespace:         "argocd",
+		DestinationServer: "https://kubernetes.default.svc",
+		DefaultProfile:    "dev",
+		Profiles:          DefaultProfiles(),
+		Ingress:           DefaultIngressConfig(),
+		CredentialsImage:  defaultCredentialsImage,
+	}
+}
+
+// Profile returns the profile of envType. Types without a profile get
+// legacyProfile.
+func (s ApplicationSettings) Profile(envType string) Profile {
+	if profile, ok := findProfile(s.Profiles, s.DefaultProfile, envType); ok {
+		return profile
+	}
+	return legacyProfile
+}
+
+// URL returns the address an environment will be served at once its
+// Ingress is synced, or "" if its profile doesn't expose it. req must have
+// its catalog app resolved.
+func (s ApplicationSettings) URL(req EnvironmentRequest) string {
+	if !s.Profile(req.EnvType).Ingress.Enabled {
+		return ""
+	}
+	return s.Ingress.URL(req)
+}
+
+// requestAnnotation holds the JSON-encoded EnvironmentRequest an
+// Application was rendered from, so it can be recovered later.
+const requestAnnotation = "meeseeks.io/request"
+
 type ArgoCDApplication struct {
 	APIVersion string                    `json:"apiVersion"`
 	Kind       string                    `json:"kind"`
 	Metadata   ArgoCDApplicationMetadata `json:"metadata"`
 	Spec       ArgoCDApplicationSpec     `json:"spec"`
+	Status     *ArgoCDApplicationStatus  `json:"status,omitempty"`
 }
 
 type ArgoCDApplicationMetadata struct {
-	Name      string            `json:"name"`
-	Namespace string            `json:"namespace"`
-	Labels    map[string]string `json:"labels"`
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
 }
 
 type ArgoCDApplicationSpec struct {
-	Project     string                  `json:"project"`
-	Source      ArgoCDApplicationSource `json:"source"`
-	Destination ArgoCDDestination       `json:"destination"`
-	SyncPolicy  *ArgoCDSyncPolicy       `json:"syncPolicy,omitempty"`
+	Project     string                    `json:"project"`
+	Source      *ArgoCDApplicationSource  `json:"source,omitempty"`
+	Sources     []ArgoCDApplicationSource `json:"sources,omitempty"`
+	Destination ArgoCDDestination         `json:"destination"`
+	SyncPolicy  *ArgoCDSyncPolicy         `json:"syncPolicy,omitempty"`
 }
 
 type ArgoCDApplicationSource struct {
 	RepoURL        string                 `json:"repoURL"`
 	TargetRevision string                 `json:"targetRevision"`
-	Path           string                 `json:"path"`
+	Path           string                 `json:"path,omitempty"`
+	Chart          string                 `json:"chart,omitempty"`
 	Kustomize      *ArgoCDKustomizeConfig `json:"kustomize,omitempty"`
+	Helm           *ArgoCDHelmSource      `json:"helm,omitempty"`
 	Directory      *ArgoCDDirectory       `json:"directory,omitempty"`
 }
 
+type ArgoCDHelmSource struct {
+	ReleaseName  string         `json:"releaseName,omitempty"`
+	ValuesObject map[string]any `json:"valuesObject,omitempty"`
+}
+
 type ArgoCDDirectory struct {
 	Recurse bool `json:"recurse,omitempty"`
 }
@@ -53,19 +119,18 @@ type ArgoCDKustomizeConfig struct {
 }
 
 type ArgoCDKustomizePatch struct {
-	Patch  string `json:"patch"`
-	Target struct {
-		Kind string `json:"kind"`
-		Name string `json:"name"`
-	} `json:"target"`
+	Patch  string                `json:"patch"`
+	Target ArgoCDKustomizeTarget `json:"target"`
 }
 
 type ArgoCDJsonPatch struct {
-	Target struct {
-		Kind string `json:"kind"`
-		Name string `json:"name"`
-	} `json:"target"`
-	Patch string `json:"patch"`
+	Target ArgoCDKustomizeTarget `json:"target"`
+	Patch  string                `json:"patch"`
+}
+
+type ArgoCDKustomizeTarget struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
 }
 
 type ArgoCDDestination struct {
@@ -83,36 +148,158 @@ type ArgoCDAutomatedSync struct {
 	Prune    bool `json:"prune"`
 }
 
+type ArgoCDApplicationStatus struct {
+	Summary        ArgoCDApplicationSummary     `json:"summary"`
+	Sync           ArgoCDSyncStatus             `json:"sync"`
+	Health         ArgoCDHealthStatus           `json:"health"`
+	OperationState *ArgoCDOperationState        `json:"operationState,omitempty"`
+	Resources      []ArgoCDResourceStatus       `json:"resources,omitempty"`
+	Conditions     []ArgoCDApplicationCondition `json:"conditions,omitempty"`
+	// ReconciledAt is when ArgoCD last compared the app with its spec.
+	ReconciledAt string `json:"reconciledAt,omitempty"`
+}
+
+// ArgoCDApplicationSummary holds ArgoCD's summary of an app's resources.
+// ExternalURLs are read from its Ingresses.
+type ArgoCDApplicationSummary struct {
+	ExternalURLs []string `json:"externalURLs,omitempty"`
+}
+
+type ArgoCDSyncStatus struct {
+	Status   string `json:"status"`
+	Revision string `json:"revision,omitempty"`
+}
+
+type ArgoCDHealthStatus struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type ArgoCDOperationState struct {
+	Phase      string `json:"phase"`
+	Message    string `json:"message,omitempty"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+}
+
+type ArgoCDResourceStatus struct {
+	Group     string              `json:"group,omitempty"`
+	Version   string              `json:"version,omitempty"`
+	Kind      string              `json:"kind"`
+	Namespace string              `json:"namespace,omitempty"`
+	Name      string              `json:"name"`
+	Status    string              `json:"status,omitempty"`
+	Health    *ArgoCDHealthStatus `json:"health,omitempty"`
+}
+
+type ArgoCDApplicationCondition struct {
+	Type               string `json:"type"`
+	Message            string `json:"message"`
+	LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
 type EnvironmentList struct {
 	Items []EnvironmentItem `json:"items"`
 }
 
 type EnvironmentItem struct {
-	ID     string `json:"id"`
-	Name   string `json:"name"`
-	Status string `json:"status"`
-	URL    string `json:"url"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Owner     string     `json:"owner,omitempty"`
+	Team      string     `json:"team,omitempty"`
+	CPU       string     `json:"cpu,omitempty"`
+	Memory    string     `json:"memory,omitempty"`
+	Replicas  int        `json:"replicas,omitempty"`
 }
 
-func NewArgoCDClient(baseURL, token string) *ArgoCDClient {
+// EnvironmentDetail is a single environment with the request it was created
+// from and its live ArgoCD status.
+type EnvironmentDetail struct {
+	Name            string                       `json:"name"`
+	ResourceVersion string                       `json:"resource_version"`
+	Request         EnvironmentRequest           `json:"request"`
+	URL             string                       `json:"url"`
+	SyncStatus      string                       `json:"sync_status"`
+	HealthStatus    string                       `json:"health_status"`
+	HealthMessage   string                       `json:"health_message,omitempty"`
+	Revision        string                       `json:"revision,omitempty"`
+	OperationState  *ArgoCDOperationState        `json:"operation_state,omitempty"`
+	Resources       []ArgoCDResourceStatus       `json:"resources"`
+	Conditions      []ArgoCDApplicationCondition `json:"conditions"`
+	ReconciledAt    string                       `json:"reconciled_at,omitempty"`
+}
+
+func NewArgoCDClient(baseURL, token string, catalog *Catalog) *ArgoCDClient {
 	return &ArgoCDClient{
 		baseURL: baseURL,
 		token:   token,
+		catalog: catalog,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		retry:       DefaultRetryPolicy(),
+		breaker:     NewCircuitBreaker(5, 30*time.Second),
+		callTimeout: time.Minute,
+		settings:    DefaultApplicationSettings(),
+	}
+}
+
+// WithApplicationSettings replaces the project, namespaces and domain used
+// for rendered Applications.
+func (c *ArgoCDClient) WithApplicationSettings(settings ApplicationSettings) *ArgoCDClient {
+	c.settings = settings
+	return c
+}
+
+// WithCallTimeout bounds each client method call, including retries. Zero
+// leaves calls bounded only by the caller's context.
+func (c *ArgoCDClient) WithCallTimeout(timeout time.Duration) *ArgoCDClient {
+	c.callTimeout = timeout
+	return c
+}
+
+func (c *ArgoCDClient) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+// WithRetryPolicy replaces the client's retry policy.
+func (c *ArgoCDClient) WithRetryPolicy(policy RetryPolicy) *ArgoCDClient {
+	c.retry = policy
+	return c
+}
+
+// WithCircuitBreaker replaces the client's circuit breaker.
+func (c *ArgoCDClient) WithCircuitBreaker(breaker *CircuitBreaker) *ArgoCDClient {
+	c.breaker = breaker
+	return c
+}
+
+// CircuitStatus reports the state of the client's circuit breaker.
+func (c *ArgoCDClient) CircuitStatus() CircuitStatus {
+	return c.breaker.Status()
 }
 
-func (c *ArgoCDClient) CreateApplication(req EnvironmentRequest) (string, error) {
-	app := c.buildApplication(req)
+func (c *ArgoCDClient) CreateApplication(ctx context.Context, req EnvironmentRequest) (string, error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+
+	app, err := c.buildApplication(req)
+	if err != nil {
+		return "", err
+	}
 
 	appJSON, err := json.Marshal(app)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal application: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", c.baseURL+"/api/v1/applications", bytes.NewBuffer(appJSON))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/v1/applications", bytes.NewBuffer(appJSON))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -120,328 +307,416 @@ func (c *ArgoCDClient) CreateApplication(req EnvironmentRequest) (string, error)
 	httpReq.Header.Set("Authorization", "Bearer "+c.token)
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.client.Do(httpReq)
+	resp, err := c.do(httpReq, false)
 	if err != nil {
 		return "", fmt.Errorf("failed to create application: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("ArgoCD API returned status %d", resp.StatusCode)
+		return "", newArgoCDError(resp)
 	}
 
 	return req.Name, nil
 }
 
-func (c *ArgoCDClient) ListApplications() (EnvironmentList, error) {
-	httpReq, err := http.NewRequest("GET", c.baseURL+"/api/v1/applications", nil)
+func (c *ArgoCDClient) ListApplications(ctx context.Context) (EnvironmentList, error) {
+	apps, err := c.listApplications(ctx)
 	if err != nil {
-		return EnvironmentList{}, fmt.Errorf("failed to create request: %w", err)
+		return EnvironmentList{}, err
+	}
+
+	var environments []EnvironmentItem
+	for _, app := range apps {
+		environments = append(environments, c.environmentItem(app))
+	}
+
+	return EnvironmentList{Items: environments}, nil
+}
+
+// ListEnvironmentDetails returns every environment with its live status, as
+// GetApplication would, in a single ArgoCD call.
+func (c *ArgoCDClient) ListEnvironmentDetails(ctx context.Context) ([]EnvironmentDetail, error) {
+	apps, err := c.listApplications(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]EnvironmentDetail, 0, len(apps))
+	for _, app := range apps {
+		details = append(details, c.environmentDetail(app))
+	}
+
+	return details, nil
+}
+
+// listApplications returns the Applications managed by meeseeks.
+func (c *ArgoCDClient) listApplications(ctx context.Context) ([]ArgoCDApplication, error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/applications", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Authorization", "Bearer "+c.token)
 
-	resp, err := c.client.Do(httpReq)
+	resp, err := c.do(httpReq, true)
 	if err != nil {
-		return EnvironmentList{}, fmt.Errorf("failed to list applications: %w", err)
+		return nil, fmt.Errorf("failed to list applications: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return EnvironmentList{}, fmt.Errorf("ArgoCD API returned status %d", resp.StatusCode)
+		return nil, newArgoCDError(resp)
 	}
 
-	var rawApps struct {
-		Items []struct {
-			Metadata struct {
-				Name   string            `json:"name"`
-				Labels map[string]string `json:"labels"`
-			} `json:"metadata"`
-			Status struct {
-				Health struct {
-					Status string `json:"status"`
-				} `json:"health"`
-			} `json:"status"`
-		} `json:"items"`
+	var apps struct {
+		Items []ArgoCDApplication `json:"items"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&rawApps); err != nil {
-		return EnvironmentList{}, fmt.Errorf("failed to decode response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(&apps); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	var environments []EnvironmentItem
-	for _, app := range rawApps.Items {
+	var managed []ArgoCDApplication
+	for _, app := range apps.Items {
 		if app.Metadata.Labels["managed-by"] == "meeseeks" {
-			environments = append(environments, EnvironmentItem{
-				ID:     app.Metadata.Name,
-				Name:   app.Metadata.Name,
-				Status: app.Status.Health.Status,
-				URL:    fmt.Sprintf("https://%s.dev.example.com", app.Metadata.Name),
-			})
+			managed = append(managed, app)
 		}
 	}
 
-	return EnvironmentList{Items: environments}, nil
+	return managed, nil
+}
+
+func (c *ArgoCDClient) GetApplication(ctx context.Context, name string) (EnvironmentDetail, error) {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+
+	app, err := c.getApplication(ctx, name)
+	if err != nil {
+		return EnvironmentDetail{}, err
+	}
+
+	return c.environmentDetail(app), nil
+}
+
+func (c *ArgoCDClient) getApplication(ctx context.Context, name string) (ArgoCDApplication, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/applications/"+url.PathEscape(name), nil)
+	if err != nil {
+		return ArgoCDApplication{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.do(httpReq, true)
+	if err != nil {
+		return ArgoCDApplication{}, fmt.Errorf("failed to get application: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return ArgoCDApplication{}, newArgoCDError(resp)
+	}
+
+	var app ArgoCDApplication
+	if err := json.NewDecoder(resp.Body).Decode(&app); err != nil {
+		return ArgoCDApplication{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if app.Metadata.Labels["managed-by"] != "meeseeks" {
+		return ArgoCDApplication{}, fmt.Errorf("application %s is %w", name, ErrNotManaged)
+	}
+
+	return app, nil
+}
+
+// UpdateApplication re-renders an existing environment from req and replaces
+// its Application. The update only succeeds if the Application still has the
+// given resourceVersion. Dependency Secrets live outside the Application, so
+// existing databases stay reachable.
+func (c *ArgoCDClient) UpdateApplication(ctx context.Context, req EnvironmentRequest, resourceVersion string) error {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+
+	current, err := c.getApplication(ctx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	if resourceVersion != "" && current.Metadata.ResourceVersion != resourceVersion {
+		return fmt.Errorf("%w: application %s has resourceVersion %s, expected %s",
+			ErrConflict, req.Name, current.Metadata.ResourceVersion, resourceVersion)
+	}
+
+	app, err := c.buildApplication(req)
+	if err != nil {
+		return err
+	}
+	app.Metadata.ResourceVersion = current.Metadata.ResourceVersion
+
+	appJSON, err := json.Marshal(app)
+	if err != nil {
+		return fmt.Errorf("failed to marshal application: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "PUT", c.baseURL+"/api/v1/applications/"+url.PathEscape(req.Name), bytes.NewBuffer(appJSON))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(httpReq, false)
+	if err != nil {
+		return fmt.Errorf("failed to update application: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newArgoCDError(resp)
+	}
+
+	return nil
 }
 
-func (c *ArgoCDClient) DeleteApplication(name string) error {
-	httpReq, err := http.NewRequest("DELETE", c.baseURL+"/api/v1/applications/"+name, nil)
+// DeleteApplication deletes a meeseeks-managed Application. It reads the
+// Application first so an unrelated app that happens to share the name is
+// never deleted.
+func (c *ArgoCDClient) DeleteApplication(ctx context.Context, name string) error {
+	ctx, cancel := c.withCallTimeout(ctx)
+	defer cancel()
+
+	if _, err := c.getApplication(ctx, name); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "DELETE", c.baseURL+"/api/v1/applications/"+url.PathEscape(name), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Authorization", "Bearer "+c.token)
 
-	resp, err := c.client.Do(httpReq)
+	resp, err := c.do(httpReq, true)
 	if err != nil {
 		return fmt.Errorf("failed to delete application: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("ArgoCD API returned status %d", resp.StatusCode)
+		return newArgoCDError(resp)
 	}
 
 	return nil
 }
 
-func (c *ArgoCDClient) buildApplication(req EnvironmentRequest) ArgoCDApplication {
+// environmentNamespace returns the namespace environment name deploys to.
+func environmentNamespace(name string) string {
+	return "env-" + name
+}
+
+// buildApplication renders the Application for req.
+func (c *ArgoCDClient) buildApplication(req EnvironmentRequest) (ArgoCDApplication, error) {
+	catalogApp, err := c.catalog.Lookup(req.App)
+	if err != nil {
+		return ArgoCDApplication{}, err
+	}
+	req = catalogApp.withDefaults(req)
+
+	kustomize, err := c.buildKustomizeConfig(catalogApp, req)
+	if err != nil {
+		return ArgoCDApplication{}, err
+	}
+
+	profile := c.settings.Profile(req.EnvType)
+
+	companions := []any{buildNamespace(req, profile)}
+	if profile.Namespace != nil {
+		companions = append(companions, buildGuardrails(req, profile, c.settings.Ingress.ControllerNamespace)...)
+	}
+	if profile.Ingress.Enabled {
+		companions = append(companions, buildIngress(req, catalogApp, c.settings.Ingress))
+	}
+	dependencies, err := buildDependencies(req.Dependencies, c.settings.CredentialsImage)
+	if err != nil {
+		return ArgoCDApplication{}, err
+	}
+	companions = append(companions, dependencies...)
+
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return ArgoCDApplication{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
 	app := ArgoCDApplication{
 		APIVersion: "argoproj.io/v1alpha1",
 		Kind:       "Application",
 		Metadata: ArgoCDApplicationMetadata{
 			Name:      req.Name,
-			Namespace: "argocd",
+			Namespace: c.settings.Namespace,
 			Labels: map[string]string{
 				"managed-by": "meeseeks",
 				"env-type":   req.EnvType,
+				"app":        catalogApp.Name,
+			},
+			Annotations: map[string]string{
+				requestAnnotation: string(reqJSON),
 			},
 		},
 		Spec: ArgoCDApplicationSpec{
-			Project: "default",
-			Source: ArgoCDApplicationSource{
-				RepoURL:        "https://github.com/mateothegreat/k8-byexamples-nginx",
-				TargetRevision: "master",
-				Path:           "manifests",
-			},
+			Project: c.settings.Project,
 			Destination: ArgoCDDestination{
-				Server:    "https://kubernetes.default.svc",
-				Namespace: fmt.Sprintf("env-%s", req.Name),
-			},
-			SyncPolicy: &ArgoCDSyncPolicy{
-				Automated: &ArgoCDAutomatedSync{
-					SelfHeal: true,
-					Prune:    true,
-				},
-				SyncOptions: []string{
-					"CreateNamespace=true",
-				},
+				Server:    c.settings.DestinationServer,
+				Namespace: environmentNamespace(req.Name),
 			},
+			SyncPolicy: profile.syncPolicy(),
 		},
 	}
 
-	return app
-}
+	if req.Owner != "" {
+		app.Metadata.Labels[ownerLabel] = labelValue(req.Owner)
+		app.Metadata.Annotations[ownerAnnotation] = req.Owner
+	}
+	if req.Team != "" {
+		app.Metadata.Labels[teamLabel] = labelValue(req.Team)
+		app.Metadata.Annotations[teamAnnotation] = req.Team
+	}
 
-func max(a, b int) int {
-	if a > b {
-		return a
+	if req.ExpiresAt != nil {
+		app.Metadata.Annotations[expiresAtAnnotation] = req.ExpiresAt.UTC().Format(time.RFC3339)
 	}
-	return b
-}
 
-func defaultIfEmpty(value, defaultValue string) string {
-	if value == "" {
-		return defaultValue
+	source := ArgoCDApplicationSource{
+		RepoURL:        catalogApp.RepoURL,
+		TargetRevision: req.Branch,
+		Path:           catalogApp.Path,
+		Kustomize:      kustomize,
 	}
-	return value
+	app.Spec.Sources = []ArgoCDApplicationSource{
+		source,
+		buildCompanionSource(req.Name+"-companions", companions),
+	}
+
+	return app, nil
 }
 
-func (c *ArgoCDClient) buildKustomizeConfig(req EnvironmentRequest) *ArgoCDKustomizeConfig {
-	config := &ArgoCDKustomizeConfig{
-		Images: []string{
-			fmt.Sprintf("app=your-app:%s", req.Branch),
-		},
+// environmentItem summarizes an Application for environment lists.
+func (c *ArgoCDClient) environmentItem(app ArgoCDApplication) EnvironmentItem {
+	req := requestFromApplication(app)
+	item := EnvironmentItem{
+		ID:        app.Metadata.Name,
+		Name:      app.Metadata.Name,
+		URL:       applicationURL(app),
+		ExpiresAt: parseExpiresAt(app.Metadata.Annotations[expiresAtAnnotation]),
+		Owner:     req.Owner,
+		Team:      req.Team,
+		CPU:       req.CPU,
+		Memory:    req.Memory,
+		Replicas:  req.Replicas,
 	}
-
-	if req.CPU != "" || req.Memory != "" || req.Replicas > 0 {
-		resourcePatch := c.buildResourcePatch(req)
-		config.Patches = append(config.Patches, resourcePatch)
+	if app.Status != nil {
+		item.Status = app.Status.Health.Status
 	}
+	return item
+}
 
-	for _, dep := range req.Dependencies {
-		depPatch := c.buildDependencyPatch(dep)
-		config.Patches = append(config.Patches, depPatch)
+// environmentDetail converts an Application read back from ArgoCD.
+func (c *ArgoCDClient) environmentDetail(app ArgoCDApplication) EnvironmentDetail {
+	req := requestFromApplication(app)
+	detail := EnvironmentDetail{
+		Name:            app.Metadata.Name,
+		ResourceVersion: app.Metadata.ResourceVersion,
+		Request:         req,
+		URL:             applicationURL(app),
+		Resources:       []ArgoCDResourceStatus{},
+		Conditions:      []ArgoCDApplicationCondition{},
 	}
 
-	if len(req.EnvVars) > 0 {
-		envPatch := c.buildEnvVarsPatch(req.EnvVars)
-		config.Patches = append(config.Patches, envPatch)
+	if status := app.Status; status != nil {
+		detail.SyncStatus = status.Sync.Status
+		detail.HealthStatus = status.Health.Status
+		detail.HealthMessage = status.Health.Message
+		detail.Revision = status.Sync.Revision
+		detail.OperationState = status.OperationState
+		detail.ReconciledAt = status.ReconciledAt
+		if status.Resources != nil {
+			detail.Resources = status.Resources
+		}
+		if status.Conditions != nil {
+			detail.Conditions = status.Conditions
+		}
 	}
 
-	return config
+	return detail
 }
 
-func (c *ArgoCDClient) buildResourcePatch(req EnvironmentRequest) ArgoCDKustomizePatch {
-	patch := `
-- op: replace
-  path: /spec/template/spec/containers/0/resources
-  value:
-    requests:`
-
-	if req.CPU != "" {
-		patch += fmt.Sprintf(`
-      cpu: "%s"`, req.CPU)
-	}
-	if req.Memory != "" {
-		patch += fmt.Sprintf(`
-      memory: "%s"`, req.Memory)
+// requestFromApplication recovers the request an Application was rendered
+// from. Applications created before the request annotation existed fall back
+// to what can be read from labels and the app source.
+func requestFromApplication(app ArgoCDApplication) EnvironmentRequest {
+	var req EnvironmentRequest
+	if raw := app.Metadata.Annotations[requestAnnotation]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &req); err == nil {
+			req.Owner = app.Metadata.Annotations[ownerAnnotation]
+			req.Team = app.Metadata.Annotations[teamAnnotation]
+			return req
+		}
 	}
 
-	patch += `
-    limits:`
-
-	if req.CPU != "" {
-		patch += fmt.Sprintf(`
-      cpu: "%s"`, req.CPU)
+	req = EnvironmentRequest{
+		Name:    app.Metadata.Name,
+		App:     app.Metadata.Labels["app"],
+		EnvType: app.Metadata.Labels["env-type"],
+		Owner:   app.Metadata.Annotations[ownerAnnotation],
+		Team:    app.Metadata.Annotations[teamAnnotation],
 	}
-	if req.Memory != "" {
-		patch += fmt.Sprintf(`
-      memory: "%s"`, req.Memory)
+	if source := app.Spec.Source; source != nil {
+		req.Branch = source.TargetRevision
+	} else if len(app.Spec.Sources) > 0 {
+		req.Branch = app.Spec.Sources[0].TargetRevision
 	}
+	return req
+}
 
-	if req.Replicas > 0 {
-		patch += fmt.Sprintf(`
-- op: replace
-  path: /spec/replicas
-  value: %d`, req.Replicas)
+func max(a, b int) int {
+	if a > b {
+		return a
 	}
+	return b
+}
 
-	return ArgoCDKustomizePatch{
-		Patch: patch,
-		Target: struct {
-			Kind string `json:"kind"`
-			Name string `json:"name"`
-		}{
-			Kind: "Deployment",
-			Name: "app",
-		},
+func defaultIfEmpty(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
 	}
+	return value
 }
 
-func (c *ArgoCDClient) buildDependencyPatch(dependency string) ArgoCDKustomizePatch {
-	var patch string
-
-	switch dependency {
-	case "postgresql":
-		patch = `
-apiVersion: apps/v1
-kind: Deployment
-metadata:
-  name: postgresql
-spec:
-  replicas: 1
-  selector:
-    matchLabels:
-      app: postgresql
-  template:
-    metadata:
-      labels:
-        app: postgresql
-    spec:
-      containers:
-      - name: postgresql
-        image: postgres:14
-        env:
-        - name: POSTGRES_DB
-          value: myapp
-        - name: POSTGRES_USER
-          value: user
-        - name: POSTGRES_PASSWORD
-          value: password
-        ports:
-        - containerPort: 5432`
-	case "redis":
-		patch = `
-apiVersion: apps/v1
-kind: Deployment
-metadata:
-  name: redis
-spec:
-  replicas: 1
-  selector:
-    matchLabels:
-      app: redis
-  template:
-    metadata:
-      labels:
-        app: redis
-    spec:
-      containers:
-      - name: redis
-        image: redis:7
-        ports:
-        - containerPort: 6379`
-	case "mongodb":
-		patch = `
-apiVersion: apps/v1
-kind: Deployment
-metadata:
-  name: mongodb
-spec:
-  replicas: 1
-  selector:
-    matchLabels:
-      app: mongodb
-  template:
-    metadata:
-      labels:
-        app: mongodb
-    spec:
-      containers:
-      - name: mongodb
-        image: mongo:6
-        env:
-        - name: MONGO_INITDB_ROOT_USERNAME
-          value: root
-        - name: MONGO_INITDB_ROOT_PASSWORD
-          value: password
-        ports:
-        - containerPort: 27017`
-	}
-
-	return ArgoCDKustomizePatch{
-		Patch: patch,
-		Target: struct {
-			Kind string `json:"kind"`
-			Name string `json:"name"`
-		}{
-			Kind: "Deployment",
-			Name: dependency,
+func (c *ArgoCDClient) buildKustomizeConfig(app CatalogApp, req EnvironmentRequest) (*ArgoCDKustomizeConfig, error) {
+	config := &ArgoCDKustomizeConfig{
+		Images: []string{
+			fmt.Sprintf("%s:%s", app.Image, imageTag(req.Branch)),
 		},
 	}
-}
-
-func (c *ArgoCDClient) buildEnvVarsPatch(envVars map[string]string) ArgoCDKustomizePatch {
-	patch := `
-- op: add
-  path: /spec/template/spec/containers/0/env
-  value:`
 
-	for key, value := range envVars {
-		patch += fmt.Sprintf(`
-  - name: %s
-    value: "%s"`, key, value)
+	if req.CPU != "" || req.Memory != "" || req.CPULimit != "" || req.MemoryLimit != "" || req.Replicas > 0 {
+		resourcePatch, err := c.buildResourcePatch(app, req)
+		if err != nil {
+			return nil, err
+		}
+		config.Patches = append(config.Patches, resourcePatch)
 	}
 
-	return ArgoCDKustomizePatch{
-		Patch: patch,
-		Target: struct {
-			Kind string `json:"kind"`
-			Name string `json:"name"`
-		}{
-			Kind: "Deployment",
-			Name: "app",
-		},
+	if envVars := mergeEnvVars(dependencyEnvVars(req.Dependencies), req.EnvVars); len(envVars) > 0 {
+		envPatch, err := c.buildEnvVarsPatch(app, envVars)
+		if err != nil {
+			return nil, err
+		}
+		config.Patches = append(config.Patches, envPatch)
 	}
+
+	return config, nil
 }
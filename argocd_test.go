@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"meeseeks/argocdfake"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestBuildApplicationGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		req  EnvironmentRequest
+	}{
+		{
+			name: "minimal",
+			req:  EnvironmentRequest{Name: "minimal", Branch: "main"},
+		},
+		{
+			name: "branch_and_resources",
+			req: EnvironmentRequest{
+				Name:     "feature-login",
+				Branch:   "feature/login",
+				CPU:      "250m",
+				Memory:   "256Mi",
+				Replicas: 2,
+				EnvType:  "staging",
+			},
+		},
+		{
+			name: "env_vars",
+			req: EnvironmentRequest{
+				Name:   "env-vars",
+				Branch: "main",
+				EnvVars: map[string]string{
+					"DEBUG":     "true",
+					"LOG_LEVEL": "debug",
+					"GREETING":  `say "hi": {ok}`,
+				},
+			},
+		},
+		{
+			name: "dependencies",
+			req: EnvironmentRequest{
+				Name:         "with-deps",
+				Branch:       "release-1.2",
+				Dependencies: []string{"postgresql", "redis", "mongodb"},
+				EnvVars:      map[string]string{"REDIS_URL": "redis://custom:6379/1"},
+			},
+		},
+	}
+
+	client := NewArgoCDClient("http://argocd.example.com", "token", DefaultCatalog())
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := client.buildApplication(tt.req)
+			if err != nil {
+				t.Fatalf("buildApplication: %v", err)
+			}
+			got, err := json.MarshalIndent(app, "", "  ")
+			if err != nil {
+				t.Fatalf("failed to marshal application: %v", err)
+			}
+			got = append(got, '\n')
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Application for %s doesn't match %s (run with -update if the change is intended):\n%s", tt.name, path, got)
+			}
+		})
+	}
+}
+
+// newFakeArgoCD starts an argocdfake server and returns a client for it that
+// doesn't retry, so each call maps to exactly one request.
+func newFakeArgoCD(t *testing.T) (*ArgoCDClient, *argocdfake.Fake) {
+	t.Helper()
+	server, fake := argocdfake.NewServer("secret-token")
+	t.Cleanup(server.Close)
+
+	client := NewArgoCDClient(server.URL, "secret-token", DefaultCatalog()).
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	return client, fake
+}
+
+func TestArgoCDClientRoundTrip(t *testing.T) {
+	client, fake := newFakeArgoCD(t)
+	ctx := context.Background()
+
+	req := EnvironmentRequest{Name: "round-trip", Branch: "main"}
+	if _, err := client.CreateApplication(ctx, req); err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+
+	detail, err := client.GetApplication(ctx, "round-trip")
+	if err != nil {
+		t.Fatalf("GetApplication: %v", err)
+	}
+	if detail.Request.Branch != "main" || detail.SyncStatus != "Synced" || detail.HealthStatus != "Healthy" {
+		t.Errorf("GetApplication = branch %q, %s/%s, want main, Synced/Healthy",
+			detail.Request.Branch, detail.SyncStatus, detail.HealthStatus)
+	}
+
+	list, err := client.ListApplications(ctx)
+	if err != nil {
+		t.Fatalf("ListApplications: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "round-trip" {
+		t.Errorf("ListApplications = %+v, want only round-trip", list.Items)
+	}
+
+	req.Branch = "feature/x"
+	if err := client.UpdateApplication(ctx, req, detail.ResourceVersion); err != nil {
+		t.Fatalf("UpdateApplication: %v", err)
+	}
+	detail, err = client.GetApplication(ctx, "round-trip")
+	if err != nil {
+		t.Fatalf("GetApplication after update: %v", err)
+	}
+	if detail.Request.Branch != "feature/x" {
+		t.Errorf("branch after update = %q, want feature/x", detail.Request.Branch)
+	}
+
+	if err := client.DeleteApplication(ctx, "round-trip"); err != nil {
+		t.Fatalf("DeleteApplication: %v", err)
+	}
+	if _, ok := fake.Application("round-trip"); ok {
+		t.Error("application still exists after DeleteApplication")
+	}
+
+	want := []string{
+		"POST /api/v1/applications",
+		"GET /api/v1/applications/round-trip",
+		"GET /api/v1/applications",
+		"GET /api/v1/applications/round-trip",
+		"PUT /api/v1/applications/round-trip",
+		"GET /api/v1/applications/round-trip",
+		"GET /api/v1/applications/round-trip",
+		"DELETE /api/v1/applications/round-trip",
+	}
+	var got []string
+	for _, r := range fake.Requests() {
+		got = append(got, r.Method+" "+r.Path)
+		if r.Authorization != "Bearer secret-token" {
+			t.Errorf("%s %s sent Authorization %q, want Bearer secret-token", r.Method, r.Path, r.Authorization)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestArgoCDClientErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		code       int
+		call       func(*ArgoCDClient) error
+		wantCode   int
+		wantStatus int
+	}{
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			code:   argocdfake.CodeNotFound,
+			call: func(c *ArgoCDClient) error {
+				_, err := c.GetApplication(context.Background(), "missing")
+				return err
+			},
+			wantCode:   grpcNotFound,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:   "already exists",
+			status: http.StatusConflict,
+			code:   argocdfake.CodeAlreadyExists,
+			call: func(c *ArgoCDClient) error {
+				_, err := c.CreateApplication(context.Background(), EnvironmentRequest{Name: "taken", Branch: "main"})
+				return err
+			},
+			wantCode:   grpcAlreadyExists,
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, fake := newFakeArgoCD(t)
+			fake.FailNext(tt.status, tt.code, tt.name)
+
+			err := tt.call(client)
+			var argoErr *ArgoCDError
+			if !errors.As(err, &argoErr) {
+				t.Fatalf("error = %v, want an *ArgoCDError", err)
+			}
+			if argoErr.StatusCode != tt.status || argoErr.Code != tt.wantCode || argoErr.Message != tt.name {
+				t.Errorf("error = %+v, want status %d, code %d, message %q", argoErr, tt.status, tt.wantCode, tt.name)
+			}
+			if got := httpStatusForError(err); got != tt.wantStatus {
+				t.Errorf("httpStatusForError = %d, want %d", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestArgoCDClientEscapesNames(t *testing.T) {
+	client, fake := newFakeArgoCD(t)
+	ctx := context.Background()
+	if _, err := client.CreateApplication(ctx, EnvironmentRequest{Name: "foo", Branch: "main"}); err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+
+	for _, name := range []string{"foo?cascade=false", "foo#bar"} {
+		err := client.DeleteApplication(ctx, name)
+		var argoErr *ArgoCDError
+		if !errors.As(err, &argoErr) || argoErr.Code != grpcNotFound {
+			t.Errorf("DeleteApplication(%q) error = %v, want code %d", name, err, grpcNotFound)
+		}
+		requests := fake.Requests()
+		if got, want := requests[len(requests)-1].Path, "/api/v1/applications/"+name; got != want {
+			t.Errorf("DeleteApplication(%q) requested %s, want %s", name, got, want)
+		}
+	}
+	if _, ok := fake.Application("foo"); !ok {
+		t.Error("deleting an escaped name removed foo")
+	}
+}
+
+func TestArgoCDClientCreateExisting(t *testing.T) {
+	client, _ := newFakeArgoCD(t)
+	req := EnvironmentRequest{Name: "twice", Branch: "main"}
+	if _, err := client.CreateApplication(context.Background(), req); err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+
+	_, err := client.CreateApplication(context.Background(), req)
+	var argoErr *ArgoCDError
+	if !errors.As(err, &argoErr) || argoErr.Code != grpcAlreadyExists {
+		t.Fatalf("second CreateApplication error = %v, want code %d", err, grpcAlreadyExists)
+	}
+}
+
+func TestArgoCDClientUpdateConflict(t *testing.T) {
+	client, fake := newFakeArgoCD(t)
+	ctx := context.Background()
+	req := EnvironmentRequest{Name: "contended", Branch: "main"}
+	if _, err := client.CreateApplication(ctx, req); err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+	detail, err := client.GetApplication(ctx, "contended")
+	if err != nil {
+		t.Fatalf("GetApplication: %v", err)
+	}
+
+	// Someone else changes the Application after the caller read it.
+	if err := fake.SetStatus("contended", map[string]any{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.UpdateApplication(ctx, req, detail.ResourceVersion)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("UpdateApplication with a stale resourceVersion = %v, want ErrConflict", err)
+	}
+	if got := httpStatusForError(err); got != http.StatusConflict {
+		t.Errorf("httpStatusForError = %d, want %d", got, http.StatusConflict)
+	}
+}
+
+func TestArgoCDClientUpdateRace(t *testing.T) {
+	fake := argocdfake.New("secret-token")
+	// Change the Application between the client's read and its write, so
+	// ArgoCD itself rejects the stale resourceVersion.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			if err := fake.SetStatus("raced", map[string]any{}); err != nil {
+				t.Error(err)
+			}
+		}
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	client := NewArgoCDClient(server.URL, "secret-token", DefaultCatalog()).
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1})
+
+	ctx := context.Background()
+	req := EnvironmentRequest{Name: "raced", Branch: "main"}
+	if _, err := client.CreateApplication(ctx, req); err != nil {
+		t.Fatalf("CreateApplication: %v", err)
+	}
+
+	err := client.UpdateApplication(ctx, req, "")
+	var argoErr *ArgoCDError
+	if !errors.As(err, &argoErr) || argoErr.StatusCode != http.StatusConflict || argoErr.Code != grpcAborted {
+		t.Fatalf("UpdateApplication error = %v, want 409 with code %d", err, grpcAborted)
+	}
+	if got := httpStatusForError(err); got != http.StatusConflict {
+		t.Errorf("httpStatusForError = %d, want %d", got, http.StatusConflict)
+	}
+}
@@ -0,0 +1,456 @@
+// Package argocdfake is an in-memory stand-in for the ArgoCD REST API. It
+// serves the /api/v1/applications endpoints meeseeks uses with response and
+// error bodies shaped like ArgoCD's, so the real ArgoCD client (and the whole
+// meeseeks server) can run without a cluster.
+package argocdfake
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gRPC status codes ArgoCD puts in its error bodies.
+const (
+	CodeInvalidArgument  = 3
+	CodeNotFound         = 5
+	CodeAlreadyExists    = 6
+	CodePermissionDenied = 7
+	CodeAborted          = 10
+	CodeInternal         = 13
+	CodeUnavailable      = 14
+	CodeUnauthenticated  = 16
+)
+
+// Fake is an http.Handler implementing the ArgoCD applications API.
+type Fake struct {
+	// AutoSync makes created and updated Applications report Synced and
+	// Healthy straight away, as with an automated sync policy. When false
+	// they stay OutOfSync/Missing until synced via the sync endpoint.
+	AutoSync bool
+
+	token string
+	now   func() time.Time
+
+	mu       sync.Mutex
+	apps     map[string]map[string]any
+	version  int
+	failures []failure
+	requests []Request
+}
+
+type failure struct {
+	status  int
+	code    int
+	message string
+}
+
+// Request records a call made to the fake.
+type Request struct {
+	Method        string
+	Path          string
+	Authorization string
+	Body          []byte
+}
+
+// New returns a fake that accepts only the given bearer token. An empty
+// token accepts any request.
+func New(token string) *Fake {
+	return &Fake{
+		AutoSync: true,
+		token:    token,
+		now:      time.Now,
+		apps:     make(map[string]map[string]any),
+	}
+}
+
+// NewServer starts an httptest server backed by a new fake. Callers must
+// Close the server.
+func NewServer(token string) (*httptest.Server, *Fake) {
+	fake := New(token)
+	return httptest.NewServer(fake), fake
+}
+
+// FailNext makes the next request fail with the given HTTP status, gRPC code
+// and message. Calls queue up.
+func (f *Fake) FailNext(status, code int, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, failure{status: status, code: code, message: message})
+}
+
+// Requests returns the requests received so far.
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+// Application returns a copy of a stored Application as JSON-decoded data.
+func (f *Fake) Application(name string) (map[string]any, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	app, ok := f.apps[name]
+	if !ok {
+		return nil, false
+	}
+	return deepCopy(app), true
+}
+
+// SetStatus replaces an Application's status, e.g. to simulate a Degraded
+// app or a failed sync.
+func (f *Fake) SetStatus(name string, status map[string]any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	app, ok := f.apps[name]
+	if !ok {
+		return fmt.Errorf("application %s not found", name)
+	}
+	app["status"] = deepCopy(status)
+	f.bumpVersion(app)
+	return nil
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, fmt.Sprintf("failed to read request: %v", err))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, Request{
+		Method:        r.Method,
+		Path:          r.URL.Path,
+		Authorization: r.Header.Get("Authorization"),
+		Body:          body,
+	})
+
+	if f.token != "" && r.Header.Get("Authorization") != "Bearer "+f.token {
+		writeError(w, http.StatusUnauthorized, CodeUnauthenticated, "invalid session: token signature is invalid")
+		return
+	}
+
+	if len(f.failures) > 0 {
+		next := f.failures[0]
+		f.failures = f.failures[1:]
+		writeError(w, next.status, next.code, next.message)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/api/v1/applications")
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "Not Found")
+		return
+	}
+	rest = strings.Trim(rest, "/")
+	name, action, _ := strings.Cut(rest, "/")
+
+	switch {
+	case name == "" && r.Method == http.MethodGet:
+		f.list(w, r)
+	case name == "" && r.Method == http.MethodPost:
+		f.create(w, body)
+	case name != "" && action == "" && r.Method == http.MethodGet:
+		f.get(w, name)
+	case name != "" && action == "" && r.Method == http.MethodPut:
+		f.update(w, name, body)
+	case name != "" && action == "" && r.Method == http.MethodDelete:
+		f.delete(w, name)
+	case name != "" && action == "sync" && r.Method == http.MethodPost:
+		f.sync(w, name)
+	default:
+		writeError(w, http.StatusNotImplemented, 12, fmt.Sprintf("Method %s %s not implemented", r.Method, r.URL.Path))
+	}
+}
+
+func (f *Fake) list(w http.ResponseWriter, r *http.Request) {
+	selector := parseSelector(r.URL.Query().Get("selector"))
+
+	names := make([]string, 0, len(f.apps))
+	for name := range f.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	items := []any{}
+	for _, name := range names {
+		app := f.apps[name]
+		if matchesSelector(app, selector) {
+			items = append(items, app)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"metadata": map[string]any{"resourceVersion": strconv.Itoa(f.version)},
+		"items":    items,
+	})
+}
+
+func (f *Fake) create(w http.ResponseWriter, body []byte) {
+	app, name, ok := decodeApplication(w, body)
+	if !ok {
+		return
+	}
+
+	if _, exists := f.apps[name]; exists {
+		writeError(w, http.StatusConflict, CodeAlreadyExists, fmt.Sprintf("applications.argoproj.io %q already exists", name))
+		return
+	}
+
+	metadata := app["metadata"].(map[string]any)
+	metadata["uid"] = fmt.Sprintf("%x", sha1.Sum([]byte(name+f.now().String())))[:32]
+	metadata["creationTimestamp"] = f.now().UTC().Format(time.RFC3339)
+	if _, ok := metadata["namespace"]; !ok {
+		metadata["namespace"] = "argocd"
+	}
+	f.deploy(app)
+	f.apps[name] = app
+
+	writeJSON(w, http.StatusOK, app)
+}
+
+func (f *Fake) get(w http.ResponseWriter, name string) {
+	app, ok := f.apps[name]
+	if !ok {
+		writeNotFound(w, name)
+		return
+	}
+	writeJSON(w, http.StatusOK, app)
+}
+
+func (f *Fake) update(w http.ResponseWriter, name string, body []byte) {
+	app, bodyName, ok := decodeApplication(w, body)
+	if !ok {
+		return
+	}
+	if bodyName != name {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, fmt.Sprintf("application name %q does not match %q", bodyName, name))
+		return
+	}
+
+	current, exists := f.apps[name]
+	if !exists {
+		writeNotFound(w, name)
+		return
+	}
+
+	currentMeta := current["metadata"].(map[string]any)
+	metadata := app["metadata"].(map[string]any)
+	if rv, _ := metadata["resourceVersion"].(string); rv != "" && rv != currentMeta["resourceVersion"] {
+		writeError(w, http.StatusConflict, CodeAborted, fmt.Sprintf(
+			"Operation cannot be fulfilled on applications.argoproj.io %q: the object has been modified; please apply your changes to the latest version and try again", name))
+		return
+	}
+
+	metadata["uid"] = currentMeta["uid"]
+	metadata["creationTimestamp"] = currentMeta["creationTimestamp"]
+	if _, ok := metadata["namespace"]; !ok {
+		metadata["namespace"] = currentMeta["namespace"]
+	}
+	app["status"] = current["status"]
+	f.deploy(app)
+	f.apps[name] = app
+
+	writeJSON(w, http.StatusOK, app)
+}
+
+func (f *Fake) delete(w http.ResponseWriter, name string) {
+	if _, ok := f.apps[name]; !ok {
+		writeNotFound(w, name)
+		return
+	}
+	delete(f.apps, name)
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (f *Fake) sync(w http.ResponseWriter, name string) {
+	app, ok := f.apps[name]
+	if !ok {
+		writeNotFound(w, name)
+		return
+	}
+	f.markSynced(app)
+	f.bumpVersion(app)
+	writeJSON(w, http.StatusOK, app)
+}
+
+// deploy stamps a new resourceVersion and resets the status for a newly
+// written spec. Callers must hold f.mu.
+func (f *Fake) deploy(app map[string]any) {
+	f.bumpVersion(app)
+
+	status, _ := app["status"].(map[string]any)
+	if status == nil {
+		status = map[string]any{}
+		app["status"] = status
+	}
+	status["sync"] = map[string]any{"status": "OutOfSync"}
+	status["health"] = map[string]any{"status": "Missing"}
+	status["reconciledAt"] = f.now().UTC().Format(time.RFC3339)
+	delete(status, "operationState")
+
+	if f.AutoSync {
+		f.markSynced(app)
+	}
+}
+
+func (f *Fake) markSynced(app map[string]any) {
+	revision := fakeRevision(app)
+	now := f.now().UTC().Format(time.RFC3339)
+
+	status, _ := app["status"].(map[string]any)
+	if status == nil {
+		status = map[string]any{}
+		app["status"] = status
+	}
+	status["sync"] = map[string]any{"status": "Synced", "revision": revision}
+	status["health"] = map[string]any{"status": "Healthy"}
+	status["reconciledAt"] = now
+	if urls := fakeExternalURLs(app); len(urls) > 0 {
+		status["summary"] = map[string]any{"externalURLs": urls}
+	} else {
+		delete(status, "summary")
+	}
+	status["operationState"] = map[string]any{
+		"phase":      "Succeeded",
+		"message":    "successfully synced (all tasks run)",
+		"startedAt":  now,
+		"finishedAt": now,
+		"syncResult": map[string]any{"revision": revision},
+	}
+}
+
+func (f *Fake) bumpVersion(app map[string]any) {
+	f.version++
+	app["metadata"].(map[string]any)["resourceVersion"] = strconv.Itoa(f.version)
+}
+
+func decodeApplication(w http.ResponseWriter, body []byte) (map[string]any, string, bool) {
+	var app map[string]any
+	if err := json.Unmarshal(body, &app); err != nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, fmt.Sprintf("error unmarshaling request: %v", err))
+		return nil, "", false
+	}
+
+	metadata, _ := app["metadata"].(map[string]any)
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		writeError(w, http.StatusBadRequest, CodeInvalidArgument, "application name is required")
+		return nil, "", false
+	}
+
+	delete(app, "status")
+	return app, name, true
+}
+
+// fakeRevision derives a stable commit SHA from the app's first source.
+func fakeRevision(app map[string]any) string {
+	spec, _ := app["spec"].(map[string]any)
+	source, _ := spec["source"].(map[string]any)
+	if source == nil {
+		sources, _ := spec["sources"].([]any)
+		if len(sources) > 0 {
+			source, _ = sources[0].(map[string]any)
+		}
+	}
+	repo, _ := source["repoURL"].(string)
+	revision, _ := source["targetRevision"].(string)
+	sum := sha1.Sum([]byte(repo + "@" + revision))
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeExternalURLs lists the hosts of the Ingresses in the app's Helm
+// values, the way ArgoCD reports them once they are synced.
+func fakeExternalURLs(app map[string]any) []any {
+	spec, _ := app["spec"].(map[string]any)
+	sources, _ := spec["sources"].([]any)
+
+	var urls []any
+	for _, raw := range sources {
+		source, _ := raw.(map[string]any)
+		helm, _ := source["helm"].(map[string]any)
+		values, _ := helm["valuesObject"].(map[string]any)
+		resources, _ := values["resources"].([]any)
+		for _, r := range resources {
+			resource, _ := r.(map[string]any)
+			if resource["kind"] != "Ingress" {
+				continue
+			}
+			ingress, _ := resource["spec"].(map[string]any)
+			scheme := "http"
+			if tls, _ := ingress["tls"].([]any); len(tls) > 0 {
+				scheme = "https"
+			}
+			rules, _ := ingress["rules"].([]any)
+			for _, rr := range rules {
+				rule, _ := rr.(map[string]any)
+				if host, _ := rule["host"].(string); host != "" {
+					urls = append(urls, scheme+"://"+host+"/")
+				}
+			}
+		}
+	}
+	return urls
+}
+
+// parseSelector parses an equality-based label selector like
+// "managed-by=meeseeks,env-type=dev".
+func parseSelector(selector string) map[string]string {
+	labels := make(map[string]string)
+	for _, term := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if ok {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+func matchesSelector(app map[string]any, selector map[string]string) bool {
+	metadata, _ := app["metadata"].(map[string]any)
+	labels, _ := metadata["labels"].(map[string]any)
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func writeNotFound(w http.ResponseWriter, name string) {
+	writeError(w, http.StatusNotFound, CodeNotFound, fmt.Sprintf("applications.argoproj.io %q not found", name))
+}
+
+// writeError writes an error body the way ArgoCD's grpc-gateway does.
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error":   message,
+		"code":    code,
+		"message": message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func deepCopy(value map[string]any) map[string]any {
+	data, _ := json.Marshal(value)
+	var out map[string]any
+	json.Unmarshal(data, &out)
+	return out
+}
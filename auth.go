@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ownerLabel and ownerAnnotation record who created an environment. Label
+// values are restricted, so the label holds a sanitized form of the identity
+// and the annotation holds it verbatim.
+const (
+	ownerLabel      = "owner"
+	ownerAnnotation = "meeseeks.io/owner"
+)
+
+const (
+	sessionCookie = "meeseeks_session"
+	loginCookie   = "meeseeks_login"
+)
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	// Method is how the caller authenticated: "token" or "oidc".
+	Method string `json:"method"`
+}
+
+type identityKey struct{}
+
+func withIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the caller authenticated by the auth middleware, or
+// nil when authentication is disabled.
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// ownerName returns the name recorded as the owner of environments created
+// in ctx, or "" when authentication is disabled.
+func ownerName(ctx context.Context) string {
+	if identity := IdentityFrom(ctx); identity != nil {
+		return identity.Name
+	}
+	return ""
+}
+
+// Authenticator identifies the caller of a request. It returns nil, nil when
+// the request carries no credentials it understands.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Auth is the authentication middleware. Each configured authenticator is
+// tried in turn; with none configured every request is let through
+// unauthenticated.
+type Auth struct {
+	authenticators []Authenticator
+	sessions       *sessionCodec
+	oidc           *OIDCProvider
+}
+
+// NewAuth sets up the authenticators enabled in cfg. OIDC discovery happens
+// here, so the issuer must be reachable at startup.
+func NewAuth(ctx context.Context, cfg AuthConfig) (*Auth, error) {
+	auth := &Auth{}
+
+	if cfg.TokensFile != "" {
+		tokens, err := LoadTokens(cfg.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		auth.authenticators = append(auth.authenticators, tokens)
+		log.Printf("Loaded %d API tokens from %s", len(tokens.identities), cfg.TokensFile)
+	}
+
+	if cfg.OIDC.IssuerURL != "" {
+		secret := []byte(cfg.SessionSecret)
+		if len(secret) == 0 {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, fmt.Errorf("failed to generate session secret: %w", err)
+			}
+			log.Println("No session secret configured; sessions will not survive a restart")
+		}
+		auth.sessions = &sessionCodec{secret: secret, ttl: cfg.SessionTTL, now: time.Now}
+
+		provider, err := NewOIDCProvider(ctx, cfg.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		auth.oidc = provider
+		auth.authenticators = append(auth.authenticators, auth.sessions)
+	}
+
+	return auth, nil
+}
+
+// Enabled reports whether any authenticator is configured.
+func (a *Auth) Enabled() bool {
+	return len(a.authenticators) > 0
+}
+
+// publicPaths are reachable without authentication.
+var publicPaths = map[string]bool{
+	"/healthz":       true,
+	"/auth/login":    true,
+	"/auth/callback": true,
+	"/auth/logout":   true,
+}
+
+// Middleware rejects unauthenticated requests and stores the caller's
+// identity in the request context. Browsers are sent to the OIDC login
+// instead of getting a bare 401.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		for _, authenticator := range a.authenticators {
+			identity, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="meeseeks"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if identity != nil {
+				next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), identity)))
+				return
+			}
+		}
+
+		if a.oidc != nil {
+			loginURL := "/auth/login"
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", loginURL)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, loginURL, http.StatusFound)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="meeseeks"`)
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+	})
+}
+
+// Register adds the OIDC login, callback and logout routes to mux.
+func (a *Auth) Register(mux *http.ServeMux) {
+	if a.oidc == nil {
+		return
+	}
+	mux.HandleFunc("/auth/login", a.login)
+	mux.HandleFunc("/auth/callback", a.callback)
+	mux.HandleFunc("/auth/logout", a.logout)
+}
+
+// loginState is kept in a short-lived signed cookie between the redirect to
+// the identity provider and the callback.
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Expires  time.Time `json:"exp"`
+}
+
+func (a *Auth) login(w http.ResponseWriter, r *http.Request) {
+	state := loginState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+		Expires:  a.sessions.now().Add(10 * time.Minute),
+	}
+
+	value, err := a.sessions.sign(loginCookie, state)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, a.cookie(r, loginCookie, value, 10*time.Minute))
+	http.Redirect(w, r, a.oidc.AuthCodeURL(state.State, state.Nonce, state.Verifier), http.StatusFound)
+}
+
+func (a *Auth) callback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+
+	var state loginState
+	if err := a.sessions.verify(loginCookie, cookie.Value, &state); err != nil || a.sessions.now().After(state.Expires) {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, a.cookie(r, loginCookie, "", -1))
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		http.Error(w, fmt.Sprintf("Login failed: %s %s", errCode, r.URL.Query().Get("error_description")), http.StatusUnauthorized)
+		return
+	}
+	if !hmac.Equal([]byte(r.URL.Query().Get("state")), []byte(state.State)) {
+		http.Error(w, "Login failed: state mismatch", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := a.oidc.Exchange(r.Context(), r.URL.Query().Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		http.Error(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	value, err := a.sessions.sign(sessionCookie, session{Identity: *identity, Expires: a.sessions.now().Add(a.sessions.ttl)})
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User %s logged in", identity.Name)
+	http.SetCookie(w, a.cookie(r, sessionCookie, value, a.sessions.ttl))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (a *Auth) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, a.cookie(r, sessionCookie, "", -1))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (a *Auth) cookie(r *http.Request, name, value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(a.oidc.config.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(maxAge.Seconds()),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// session is the payload of the session cookie.
+type session struct {
+	Identity Identity  `json:"identity"`
+	Expires  time.Time `json:"exp"`
+}
+
+// sessionCodec signs cookie payloads with HMAC-SHA256. The cookie name is
+// part of the signature so one cookie can't be replayed as another. Payloads
+// are signed, not encrypted, so they must not hold secrets.
+type sessionCodec struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func (c *sessionCodec) sign(name string, payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + c.mac(name, encoded), nil
+}
+
+func (c *sessionCodec) verify(name, value string, payload any) error {
+	encoded, mac, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(c.mac(name, encoded))) {
+		return fmt.Errorf("invalid signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, payload)
+}
+
+func (c *sessionCodec) mac(name, encoded string) string {
+	h := hmac.New(sha256.New, c.secret)
+	h.Write([]byte(name + "\x00" + encoded))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Authenticate accepts a valid, unexpired session cookie. A bad or expired
+// cookie is treated as no cookie so the user is sent to log in again.
+func (c *sessionCodec) Authenticate(r *http.Request) (*Identity, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil
+	}
+
+	var s session
+	if err := c.verify(sessionCookie, cookie.Value, &s); err != nil || c.now().After(s.Expires) || s.Identity.Name == "" {
+		return nil, nil
+	}
+	return &s.Identity, nil
+}
+
+// TokenAuthenticator accepts static bearer tokens, e.g. for CI.
+type TokenAuthenticator struct {
+	// identities is keyed by the SHA-256 of the token so lookups don't
+	// compare secrets directly.
+	identities map[string]Identity
+}
+
+type tokensFile struct {
+	Tokens []struct {
+		Name   string   `yaml:"name"`
+		Token  string   `yaml:"token"`
+		Groups []string `yaml:"groups"`
+	} `yaml:"tokens"`
+}
+
+// LoadTokens reads static API tokens from a YAML file.
+func LoadTokens(path string) (*TokenAuthenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens: %w", err)
+	}
+
+	var file tokensFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse tokens: %w", err)
+	}
+
+	authenticator := &TokenAuthenticator{identities: make(map[string]Identity)}
+	for i, entry := range file.Tokens {
+		if entry.Name == "" {
+			return nil, fmt.Errorf("invalid tokens %s: token %d: name cannot be empty", path, i)
+		}
+		if len(entry.Token) < 16 {
+			return nil, fmt.Errorf("invalid tokens %s: token %s: must be at least 16 characters", path, entry.Name)
+		}
+		hash := hashToken(entry.Token)
+		if _, ok := authenticator.identities[hash]; ok {
+			return nil, fmt.Errorf("invalid tokens %s: token %s: duplicate token", path, entry.Name)
+		}
+		authenticator.identities[hash] = Identity{Name: entry.Name, Groups: entry.Groups, Method: "token"}
+	}
+
+	return authenticator, nil
+}
+
+func (t *TokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, nil
+	}
+
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("unsupported authorization scheme")
+	}
+
+	identity, ok := t.identities[hashToken(token)]
+	if !ok {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return &identity, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+var labelInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// labelValue turns an identity name such as an email address into a
+// valid label value, e.g. jane@example.com -> jane_example.com.
+func labelValue(name string) string {
+	value := labelInvalidChars.ReplaceAllString(name, "_")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "_.-")
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSessions() *sessionCodec {
+	return &sessionCodec{secret: []byte("test-secret"), ttl: time.Hour, now: func() time.Time { return testNow }}
+}
+
+// withCookie returns a request that carries a cookie.
+func withCookie(name, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/environments", nil)
+	r.AddCookie(&http.Cookie{Name: name, Value: value})
+	return r
+}
+
+func TestSessionCookie(t *testing.T) {
+	sessions := newTestSessions()
+	jane := Identity{Name: "jane@example.com", Groups: []string{"platform"}, Method: "oidc"}
+
+	sign := func(codec *sessionCodec, name string, payload any) string {
+		t.Helper()
+		value, err := codec.sign(name, payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	valid := sign(sessions, sessionCookie, session{Identity: jane, Expires: testNow.Add(time.Hour)})
+	payload, mac, _ := strings.Cut(valid, ".")
+	forgedPayload, _, _ := strings.Cut(sign(sessions, sessionCookie, session{Identity: Identity{Name: "admin"}, Expires: testNow.Add(time.Hour)}), ".")
+	otherSecret := &sessionCodec{secret: []byte("other-secret"), now: sessions.now}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "valid", value: valid, want: jane.Name},
+		{name: "payload swapped", value: forgedPayload + "." + mac},
+		{name: "signature altered", value: payload + "." + strings.ToUpper(mac)},
+		{name: "signature missing", value: payload},
+		{name: "other secret", value: sign(otherSecret, sessionCookie, session{Identity: jane, Expires: testNow.Add(time.Hour)})},
+		{name: "expired", value: sign(sessions, sessionCookie, session{Identity: jane, Expires: testNow.Add(-time.Second)})},
+		{name: "login cookie replayed", value: sign(sessions, loginCookie, session{Identity: jane, Expires: testNow.Add(time.Hour)})},
+		{name: "no name", value: sign(sessions, sessionCookie, session{Expires: testNow.Add(time.Hour)})},
+		{name: "garbage", value: "not-a-cookie"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := sessions.Authenticate(withCookie(sessionCookie, tt.value))
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if tt.want == "" {
+				if identity != nil {
+					t.Errorf("Authenticate = %+v, want no identity", identity)
+				}
+				return
+			}
+			if identity == nil || identity.Name != tt.want || len(identity.Groups) != 1 {
+				t.Errorf("Authenticate = %+v, want %s in platform", identity, tt.want)
+			}
+		})
+	}
+}
+
+func writeTokens(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	tokens, err := LoadTokens(writeTokens(t, `
+tokens:
+  - name: ci
+    token: ci-token-0123456789
+    groups: [ci]
+`))
+	if err != nil {
+		t.Fatalf("LoadTokens: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{name: "known token", header: "Bearer ci-token-0123456789", want: "ci"},
+		{name: "unknown token", header: "Bearer ci-token-9876543210", wantErr: true},
+		{name: "other scheme", header: "Basic Y2k6Y2k=", wantErr: true},
+		{name: "no header"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/environments", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			identity, err := tokens.Authenticate(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate = %+v, want an error", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if tt.want == "" {
+				if identity != nil {
+					t.Errorf("Authenticate = %+v, want no identity", identity)
+				}
+				return
+			}
+			if identity == nil || identity.Name != tt.want || identity.Method != "token" {
+				t.Errorf("Authenticate = %+v, want %s by token", identity, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTokensRejectsBadFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "short token", content: "tokens: [{name: ci, token: short}]", wantErr: "at least 16 characters"},
+		{name: "missing name", content: "tokens: [{token: ci-token-0123456789}]", wantErr: "name cannot be empty"},
+		{name: "duplicate token", content: "tokens: [{name: a, token: ci-token-0123456789}, {name: b, token: ci-token-0123456789}]", wantErr: "duplicate token"},
+		{name: "not yaml", content: "tokens: [", wantErr: "failed to parse tokens"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadTokens(writeTokens(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadTokens error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRequiresAuthentication(t *testing.T) {
+	tokens, err := LoadTokens(writeTokens(t, "tokens: [{name: ci, token: ci-token-0123456789}]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &Auth{authenticators: []Authenticator{tokens}}
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(ownerName(r.Context())))
+	}))
+
+	tests := []struct {
+		path       string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/environments", wantStatus: http.StatusUnauthorized},
+		{path: "/environments", header: "Bearer wrong-token-0123456789", wantStatus: http.StatusUnauthorized},
+		{path: "/environments", header: "Bearer ci-token-0123456789", wantStatus: http.StatusOK, wantBody: "ci"},
+		{path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.wantStatus || (tt.wantStatus == http.StatusOK && rec.Body.String() != tt.wantBody) {
+			t.Errorf("GET %s with %q = %d %q, want %d %q", tt.path, tt.header, rec.Code, rec.Body, tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+
+	// login starts a login and returns its cookie and the parameters sent
+	// to the identity provider.
+	login := func(t *testing.T, auth *Auth) (*http.Cookie, url.Values) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		auth.login(rec, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil || rec.Code != http.StatusFound {
+			t.Fatalf("login returned %d to %q", rec.Code, rec.Header().Get("Location"))
+		}
+		return rec.Result().Cookies()[0], location.Query()
+	}
+
+	tests := []struct {
+		name       string
+		callback   func(cookie *http.Cookie, params url.Values) *http.Request
+		challenge  func(params url.Values) string
+		advance    time.Duration
+		wantStatus int
+	}{
+		{
+			name: "valid",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+params.Get("state"), nil)
+				r.AddCookie(cookie)
+				return r
+			},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name: "state mismatch",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state=forged", nil)
+				r.AddCookie(cookie)
+				return r
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "no login cookie",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+params.Get("state"), nil)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "tampered login cookie",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+params.Get("state"), nil)
+				r.AddCookie(&http.Cookie{Name: cookie.Name, Value: "x" + cookie.Value})
+				return r
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "login expired",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+params.Get("state"), nil)
+				r.AddCookie(cookie)
+				return r
+			},
+			advance:    11 * time.Minute,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "PKCE verifier mismatch",
+			callback: func(cookie *http.Cookie, params url.Values) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=code&state="+params.Get("state"), nil)
+				r.AddCookie(cookie)
+				return r
+			},
+			challenge:  func(url.Values) string { return "challenge-of-another-login" },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := testNow
+			sessions := newTestSessions()
+			sessions.now = func() time.Time { return now }
+			auth := &Auth{sessions: sessions, oidc: idp.provider(t)}
+			auth.authenticators = []Authenticator{sessions}
+
+			cookie, params := login(t, auth)
+			claims := idp.claims()
+			claims["nonce"] = params.Get("nonce")
+			idp.idToken = idp.sign(t, "RS256", "rsa", claims)
+			idp.challenge = params.Get("code_challenge")
+			if tt.challenge != nil {
+				idp.challenge = tt.challenge(params)
+			}
+			now = now.Add(tt.advance)
+
+			rec := httptest.NewRecorder()
+			auth.callback(rec, tt.callback(cookie, params))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("callback returned %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusFound {
+				return
+			}
+
+			var session *http.Cookie
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == sessionCookie {
+					session = c
+				}
+			}
+			if session == nil {
+				t.Fatal("callback set no session cookie")
+			}
+			identity, err := sessions.Authenticate(withCookie(sessionCookie, session.Value))
+			if err != nil || identity == nil || identity.Name != "jane@example.com" {
+				t.Errorf("session authenticates as %+v (%v), want jane@example.com", identity, err)
+			}
+		})
+	}
+}
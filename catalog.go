@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog is the set of applications meeseeks is allowed to deploy.
+type Catalog struct {
+	// Default is the app used when a request does not name one.
+	Default string       `yaml:"default" json:"default"`
+	Apps    []CatalogApp `yaml:"apps" json:"apps"`
+}
+
+// CatalogApp describes where an application's manifests live and how the
+// generated Kustomize overlay should address its workload.
+type CatalogApp struct {
+	Name       string `yaml:"name" json:"name"`
+	RepoURL    string `yaml:"repo_url" json:"repo_url"`
+	Path       string `yaml:"path" json:"path"`
+	Image      string `yaml:"image" json:"image"`
+	Deployment string `yaml:"deployment" json:"deployment"`
+	Container  string `yaml:"container" json:"container"`
+	// Service and Port are where the environment's Ingress sends traffic.
+	// They default to the deployment's name and port 80.
+	Service   string           `yaml:"service,omitempty" json:"service,omitempty"`
+	Port      int              `yaml:"port,omitempty" json:"port,omitempty"`
+	Resources CatalogResources `yaml:"resources" json:"resources"`
+	// Dependencies lists the dependencies this app may request. An empty
+	// list allows every dependency meeseeks supports.
+	Dependencies []string `yaml:"dependencies" json:"dependencies"`
+}
+
+type CatalogResources struct {
+	CPU         string `yaml:"cpu" json:"cpu"`
+	Memory      string `yaml:"memory" json:"memory"`
+	CPULimit    string `yaml:"cpu_limit" json:"cpu_limit"`
+	MemoryLimit string `yaml:"memory_limit" json:"memory_limit"`
+	Replicas    int    `yaml:"replicas" json:"replicas"`
+}
+
+var imageTagInvalidChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// DefaultCatalog returns the single-app catalog used when no catalog file is
+// configured.
+func DefaultCatalog() *Catalog {
+	return &Catalog{
+		Default: "nginx",
+		Apps: []CatalogApp{
+			{
+				Name:       "nginx",
+				RepoURL:    "https://github.com/mateothegreat/k8-byexamples-nginx",
+				Path:       "manifests",
+				Image:      "nginx",
+				Deployment: "app",
+				Container:  "app",
+			},
+		},
+	}
+}
+
+// LoadCatalog reads a catalog from a YAML or JSON file.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("failed to parse catalog: %w", err)
+	}
+
+	if err := catalog.validate(); err != nil {
+		return nil, fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+
+	return &catalog, nil
+}
+
+func (c *Catalog) validate() error {
+	if len(c.Apps) == 0 {
+		return fmt.Errorf("catalog must define at least one app")
+	}
+
+	seen := make(map[string]bool)
+	for i, app := range c.Apps {
+		if app.Name == "" {
+			return fmt.Errorf("app %d: name cannot be empty", i)
+		}
+		if seen[app.Name] {
+			return fmt.Errorf("app %s: duplicate name", app.Name)
+		}
+		seen[app.Name] = true
+
+		if app.RepoURL == "" {
+			return fmt.Errorf("app %s: repo_url cannot be empty", app.Name)
+		}
+		if app.Image == "" {
+			return fmt.Errorf("app %s: image cannot be empty", app.Name)
+		}
+		if app.Deployment == "" {
+			return fmt.Errorf("app %s: deployment cannot be empty", app.Name)
+		}
+		if app.Service != "" {
+			if err := validateName(app.Service); err != nil {
+				return fmt.Errorf("app %s: service: %w", app.Name, err)
+			}
+		}
+		if app.Port < 0 || app.Port > 65535 {
+			return fmt.Errorf("app %s: port must be between 1 and 65535", app.Name)
+		}
+		if app.Resources.CPU != "" {
+			if err := validateCPU(app.Resources.CPU); err != nil {
+				return fmt.Errorf("app %s: %w", app.Name, err)
+			}
+		}
+		if app.Resources.Memory != "" {
+			if err := validateMemory(app.Resources.Memory); err != nil {
+				return fmt.Errorf("app %s: %w", app.Name, err)
+			}
+		}
+		if app.Resources.CPULimit != "" {
+			if err := validateCPU(app.Resources.CPULimit); err != nil {
+				return fmt.Errorf("app %s: cpu_limit: %w", app.Name, err)
+			}
+		}
+		if app.Resources.MemoryLimit != "" {
+			if err := validateMemory(app.Resources.MemoryLimit); err != nil {
+				return fmt.Errorf("app %s: memory_limit: %w", app.Name, err)
+			}
+		}
+		if err := validateLimit(app.Resources.CPU, app.Resources.CPULimit); err != nil {
+			return fmt.Errorf("app %s: cpu_limit: %w", app.Name, err)
+		}
+		if err := validateLimit(app.Resources.Memory, app.Resources.MemoryLimit); err != nil {
+			return fmt.Errorf("app %s: memory_limit: %w", app.Name, err)
+		}
+		if err := validateDependencies(app.Dependencies); err != nil {
+			return fmt.Errorf("app %s: %w", app.Name, err)
+		}
+	}
+
+	if c.Default != "" && !seen[c.Default] {
+		return fmt.Errorf("default app %s is not defined", c.Default)
+	}
+
+	return nil
+}
+
+// Lookup resolves an app by name. An empty name resolves to the catalog
+// default, or the first app when no default is set.
+func (c *Catalog) Lookup(name string) (CatalogApp, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" && len(c.Apps) > 0 {
+		return c.Apps[0], nil
+	}
+
+	for _, app := range c.Apps {
+		if app.Name == name {
+			return app, nil
+		}
+	}
+
+	return CatalogApp{}, fmt.Errorf("unknown app: %s. Available: %s", name, strings.Join(c.Names(), ", "))
+}
+
+// Names returns the app names in catalog order.
+func (c *Catalog) Names() []string {
+	names := make([]string, 0, len(c.Apps))
+	for _, app := range c.Apps {
+		names = append(names, app.Name)
+	}
+	return names
+}
+
+// ValidateRequest checks the parts of a request that depend on the catalog:
+// the app must exist and may only use the dependencies it allows.
+func (c *Catalog) ValidateRequest(req EnvironmentRequest) error {
+	var errs ValidationErrors
+
+	app, err := c.Lookup(req.App)
+	if err != nil {
+		errs.Add("app", invalid(codeUnknown, "%v", err))
+		return errs.Err()
+	}
+
+	if len(app.Dependencies) == 0 {
+		return nil
+	}
+
+	for i, dep := range req.Dependencies {
+		if !slices.Contains(app.Dependencies, dep) {
+			errs.Add(fmt.Sprintf("dependencies[%d]", i), invalid(codeNotAllowed, "app %s does not allow %s. Allowed: %s", app.Name, dep, strings.Join(app.Dependencies, ", ")))
+		}
+	}
+
+	return errs.Err()
+}
+
+func (a CatalogApp) serviceName() string {
+	return defaultIfEmpty(a.Service, a.Deployment)
+}
+
+func (a CatalogApp) servicePort() int {
+	if a.Port == 0 {
+		return 80
+	}
+	return a.Port
+}
+
+// WithDefaults resolves req's app and fills in its resource settings from the
+// app's defaults. An unknown app leaves req unchanged.
+func (c *Catalog) WithDefaults(req EnvironmentRequest) EnvironmentRequest {
+	app, err := c.Lookup(req.App)
+	if err != nil {
+		return req
+	}
+	return app.withDefaults(req)
+}
+
+// withDefaults names the app in the request and fills in its resource
+// settings from the app's defaults where the request leaves them unset.
+func (a CatalogApp) withDefaults(req EnvironmentRequest) EnvironmentRequest {
+	req.App = a.Name
+	req.CPU = defaultIfEmpty(req.CPU, a.Resources.CPU)
+	req.Memory = defaultIfEmpty(req.Memory, a.Resources.Memory)
+	req.CPULimit = defaultIfEmpty(req.CPULimit, a.Resources.CPULimit)
+	req.MemoryLimit = defaultIfEmpty(req.MemoryLimit, a.Resources.MemoryLimit)
+	if req.Replicas == 0 {
+		req.Replicas = a.Resources.Replicas
+	}
+	return req
+}
+
+// imageTag turns a branch name into a valid image tag, e.g. feature/x -> feature-x.
+func imageTag(branch string) string {
+	tag := imageTagInvalidChars.ReplaceAllString(branch, "-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	return tag
+}
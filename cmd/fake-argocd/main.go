@@ -0,0 +1,27 @@
+// Command fake-argocd serves the in-memory ArgoCD API from package
+// argocdfake so meeseeks can be run end to end without a cluster:
+//
+//	go run ./cmd/fake-argocd -addr :30080 -token dev-token
+//	ARGOCD_URL=http://localhost:30080 ARGOCD_TOKEN=dev-token go run .
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"meeseeks/argocdfake"
+)
+
+func main() {
+	addr := flag.String("addr", ":30080", "address to listen on")
+	token := flag.String("token", "dev-token", "bearer token clients must send (empty accepts any)")
+	manualSync := flag.Bool("manual-sync", false, "leave applications OutOfSync until synced via the API")
+	flag.Parse()
+
+	fake := argocdfake.New(*token)
+	fake.AutoSync = !*manualSync
+
+	log.Printf("Fake ArgoCD API listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, fake))
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is meeseeks' effective configuration. Values are layered with
+// increasing precedence: built-in defaults, the YAML config file, environment
+// variables, then command-line flags.
+type Config struct {
+	Port    string `yaml:"port"`
+	DevMode bool   `yaml:"dev_mode"`
+	// CatalogFile is the application catalog to load. Empty uses the
+	// built-in single-app catalog.
+	CatalogFile string `yaml:"catalog_file"`
+
+	ArgoCD       ArgoCDConfig       `yaml:"argocd"`
+	Environments EnvironmentsConfig `yaml:"environments"`
+	Ingress      IngressConfig      `yaml:"ingress"`
+	Server       ServerConfig       `yaml:"server"`
+	Reaper       ReaperConfig       `yaml:"reaper"`
+	Events       EventsConfig       `yaml:"events"`
+	Auth         AuthConfig         `yaml:"auth"`
+	Quotas       QuotaConfig        `yaml:"quotas"`
+}
+
+type ArgoCDConfig struct {
+	URL   string `yaml:"url"`
+	Token string `yaml:"token"`
+	// Project, Namespace and DestinationServer are where Applications are
+	// created and where they deploy to.
+	Project           string        `yaml:"project"`
+	Namespace         string        `yaml:"namespace"`
+	DestinationServer string        `yaml:"destination_server"`
+	RetryAttempts     int           `yaml:"retry_attempts"`
+	BreakerThreshold  int           `yaml:"breaker_threshold"`
+	BreakerCooldown   time.Duration `yaml:"breaker_cooldown"`
+	CallTimeout       time.Duration `yaml:"call_timeout"`
+}
+
+type EnvironmentsConfig struct {
+	// AllowedDependencies restricts what requests may ask for.
+	AllowedDependencies []string `yaml:"allowed_dependencies"`
+	// DefaultProfile is the environment type of requests without an
+	// env_type.
+	DefaultProfile string `yaml:"default_profile"`
+	// Profiles are the environment types requests may use, in the order
+	// the UI lists them.
+	Profiles []Profile `yaml:"profiles"`
+	// CredentialsImage runs the Jobs that generate dependency passwords. It
+	// needs kubectl and a POSIX shell.
+	CredentialsImage string `yaml:"credentials_image"`
+}
+
+// Profile returns the profile for envType, or the default profile when
+// envType is empty.
+func (c EnvironmentsConfig) Profile(envType string) (Profile, bool) {
+	return findProfile(c.Profiles, c.DefaultProfile, envType)
+}
+
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+}
+
+type ReaperConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// EventsConfig controls the environment event streams.
+type EventsConfig struct {
+	// Interval is how often ArgoCD is polled for changes while a stream is
+	// open.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// AuthConfig enables authentication. With neither a tokens file nor an OIDC
+// issuer set, the API and UI are open to anyone who can reach them.
+type AuthConfig struct {
+	// TokensFile lists static bearer tokens, e.g. for CI.
+	TokensFile string `yaml:"tokens_file"`
+	// SessionSecret signs UI session cookies. If unset a random one is
+	// generated at startup.
+	SessionSecret string        `yaml:"session_secret"`
+	SessionTTL    time.Duration `yaml:"session_ttl"`
+	OIDC          OIDCConfig    `yaml:"oidc"`
+	// AdminGroups may change every environment; everyone else may only
+	// change the environments they own.
+	AdminGroups []string `yaml:"admin_groups"`
+}
+
+type OIDCConfig struct {
+	IssuerURL    string   `yaml:"issuer_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+	// UsernameClaim names the ID token claim used as the user's identity;
+	// sub is used when it is missing.
+	UsernameClaim string `yaml:"username_claim"`
+	GroupsClaim   string `yaml:"groups_claim"`
+}
+
+const redacted = "REDACTED"
+
+func DefaultConfig() Config {
+	retry := DefaultRetryPolicy()
+	timeouts := DefaultServerTimeouts()
+	settings := DefaultApplicationSettings()
+
+	return Config{
+		Port: "22282",
+		ArgoCD: ArgoCDConfig{
+			URL:               "http://localhost:30080",
+			Project:           settings.Project,
+			Namespace:         settings.Namespace,
+			DestinationServer: settings.DestinationServer,
+			RetryAttempts:     retry.MaxAttempts,
+			BreakerThreshold:  5,
+			BreakerCooldown:   30 * time.Second,
+			CallTimeout:       time.Minute,
+		},
+		Environments: EnvironmentsConfig{
+			AllowedDependencies: []string{"postgresql", "redis", "mongodb"},
+			DefaultProfile:      settings.DefaultProfile,
+			Profiles:            settings.Profiles,
+			CredentialsImage:    settings.CredentialsImage,
+		},
+		Ingress: settings.Ingress,
+		Server: ServerConfig{
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			ReadTimeout:       timeouts.Read,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+			ShutdownTimeout:   timeouts.Shutdown,
+		},
+		Reaper: ReaperConfig{
+			Interval: 5 * time.Minute,
+		},
+		Events: EventsConfig{
+			Interval: 5 * time.Second,
+		},
+		Auth: AuthConfig{
+			SessionTTL: 12 * time.Hour,
+			OIDC: OIDCConfig{
+				Scopes:        []string{"openid", "email", "profile"},
+				UsernameClaim: "email",
+				GroupsClaim:   "groups",
+			},
+		},
+	}
+}
+
+// setting binds one config field to an environment variable and, optionally,
+// a command-line flag.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	isBool bool
+	set    func(value string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{env: "PORT", flag: "port", usage: "port to listen on", set: stringSetter(&c.Port)},
+		{env: "DEV_MODE", flag: "dev-mode", usage: "use the in-memory mock instead of ArgoCD", isBool: true, set: boolSetter(&c.DevMode)},
+		{env: "CATALOG_FILE", flag: "catalog", usage: "application catalog file", set: stringSetter(&c.CatalogFile)},
+		// DOMAIN_SUFFIX is the old name of BASE_DOMAIN.
+		{env: "DOMAIN_SUFFIX", set: stringSetter(&c.Ingress.BaseDomain)},
+		{env: "BASE_DOMAIN", flag: "base-domain", usage: "domain environment hosts are built under", set: stringSetter(&c.Ingress.BaseDomain)},
+		{env: "INGRESS_HOST_TEMPLATE", set: stringSetter(&c.Ingress.HostTemplate)},
+		{env: "INGRESS_CLASS", flag: "ingress-class", usage: "IngressClass of environment Ingresses", set: stringSetter(&c.Ingress.ClassName)},
+		{env: "INGRESS_CLUSTER_ISSUER", flag: "ingress-cluster-issuer", usage: "cert-manager ClusterIssuer for environment certificates", set: stringSetter(&c.Ingress.ClusterIssuer)},
+		{env: "ARGOCD_URL", flag: "argocd-url", usage: "ArgoCD server URL", set: stringSetter(&c.ArgoCD.URL)},
+		{env: "ARGOCD_TOKEN", set: stringSetter(&c.ArgoCD.Token)},
+		{env: "ARGOCD_PROJECT", flag: "argocd-project", usage: "ArgoCD project for Applications", set: stringSetter(&c.ArgoCD.Project)},
+		{env: "ARGOCD_NAMESPACE", flag: "argocd-namespace", usage: "namespace Applications are created in", set: stringSetter(&c.ArgoCD.Namespace)},
+		{env: "ARGOCD_DESTINATION_SERVER", flag: "argocd-destination-server", usage: "cluster environments are deployed to", set: stringSetter(&c.ArgoCD.DestinationServer)},
+		{env: "ARGOCD_RETRY_ATTEMPTS", set: intSetter(&c.ArgoCD.RetryAttempts)},
+		{env: "ARGOCD_BREAKER_THRESHOLD", set: intSetter(&c.ArgoCD.BreakerThreshold)},
+		{env: "ARGOCD_BREAKER_COOLDOWN", set: durationSetter(&c.ArgoCD.BreakerCooldown)},
+		{env: "ARGOCD_CALL_TIMEOUT", set: durationSetter(&c.ArgoCD.CallTimeout)},
+		{env: "ALLOWED_DEPENDENCIES", flag: "allowed-dependencies", usage: "comma-separated dependencies requests may use", set: listSetter(&c.Environments.AllowedDependencies)},
+		{env: "DEFAULT_PROFILE", flag: "default-profile", usage: "environment type of requests without an env_type", set: stringSetter(&c.Environments.DefaultProfile)},
+		{env: "CREDENTIALS_IMAGE", flag: "credentials-image", usage: "image with kubectl and a shell that generates dependency passwords", set: stringSetter(&c.Environments.CredentialsImage)},
+		{env: "INGRESS_CONTROLLER_NAMESPACE", flag: "ingress-controller-namespace", usage: "namespace the ingress controller runs in", set: stringSetter(&c.Ingress.ControllerNamespace)},
+		{env: "HTTP_READ_HEADER_TIMEOUT", set: durationSetter(&c.Server.ReadHeaderTimeout)},
+		{env: "HTTP_READ_TIMEOUT", set: durationSetter(&c.Server.ReadTimeout)},
+		{env: "HTTP_WRITE_TIMEOUT", set: durationSetter(&c.Server.WriteTimeout)},
+		{env: "HTTP_IDLE_TIMEOUT", set: durationSetter(&c.Server.IdleTimeout)},
+		{env: "SHUTDOWN_TIMEOUT", set: durationSetter(&c.Server.ShutdownTimeout)},
+		{env: "REAPER_INTERVAL", set: durationSetter(&c.Reaper.Interval)},
+		{env: "EVENTS_INTERVAL", set: durationSetter(&c.Events.Interval)},
+		{env: "AUTH_TOKENS_FILE", flag: "auth-tokens-file", usage: "YAML file of static API tokens", set: stringSetter(&c.Auth.TokensFile)},
+		{env: "ADMIN_GROUPS", flag: "admin-groups", usage: "comma-separated groups allowed to change any environment", set: listSetter(&c.Auth.AdminGroups)},
+		{env: "QUOTA_OWNER_MAX_ENVIRONMENTS", set: intSetter(&c.Quotas.Owner.MaxEnvironments)},
+		{env: "QUOTA_OWNER_MAX_CPU", set: stringSetter(&c.Quotas.Owner.MaxCPU)},
+		{env: "QUOTA_OWNER_MAX_MEMORY", set: stringSetter(&c.Quotas.Owner.MaxMemory)},
+		{env: "QUOTA_TEAM_MAX_ENVIRONMENTS", set: intSetter(&c.Quotas.Team.MaxEnvironments)},
+		{env: "QUOTA_TEAM_MAX_CPU", set: stringSetter(&c.Quotas.Team.MaxCPU)},
+		{env: "QUOTA_TEAM_MAX_MEMORY", set: stringSetter(&c.Quotas.Team.MaxMemory)},
+		{env: "SESSION_SECRET", set: stringSetter(&c.Auth.SessionSecret)},
+		{env: "SESSION_TTL", set: durationSetter(&c.Auth.SessionTTL)},
+		{env: "OIDC_ISSUER_URL", flag: "oidc-issuer-url", usage: "OpenID Connect issuer for UI login", set: stringSetter(&c.Auth.OIDC.IssuerURL)},
+		{env: "OIDC_CLIENT_ID", flag: "oidc-client-id", usage: "OpenID Connect client ID", set: stringSetter(&c.Auth.OIDC.ClientID)},
+		{env: "OIDC_CLIENT_SECRET", set: stringSetter(&c.Auth.OIDC.ClientSecret)},
+		{env: "OIDC_REDIRECT_URL", flag: "oidc-redirect-url", usage: "OpenID Connect callback URL, ending in /auth/callback", set: stringSetter(&c.Auth.OIDC.RedirectURL)},
+		{env: "OIDC_SCOPES", set: listSetter(&c.Auth.OIDC.Scopes)},
+		{env: "OIDC_USERNAME_CLAIM", set: stringSetter(&c.Auth.OIDC.UsernameClaim)},
+		{env: "OIDC_GROUPS_CLAIM", set: stringSetter(&c.Auth.OIDC.GroupsClaim)},
+	}
+}
+
+func stringSetter(field *string) func(string) error {
+	return func(value string) error {
+		*field = value
+		return nil
+	}
+}
+
+func boolSetter(field *bool) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func intSetter(field *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func durationSetter(field *time.Duration) func(string) error {
+	return func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration like '30s' or '5m'")
+		}
+		*field = parsed
+		return nil
+	}
+}
+
+func listSetter(field *[]string) func(string) error {
+	return func(value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field = items
+		return nil
+	}
+}
+
+// settingFlag records a flag's raw value so it can be applied after the
+// config file and environment.
+type settingFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *settingFlag) String() string     { return f.value }
+func (f *settingFlag) Set(v string) error { f.value = v; return nil }
+func (f *settingFlag) IsBoolFlag() bool   { return f.isBool }
+
+// LoadConfig builds the effective config from args (without the program
+// name) and getenv. The config file is taken from -config or MEESEEKS_CONFIG.
+// printConfig reports whether -print-config was given.
+func LoadConfig(args []string, getenv func(string) string, output io.Writer) (cfg Config, printConfig bool, err error) {
+	cfg = DefaultConfig()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("meeseeks", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", getenv("MEESEEKS_CONFIG"), "YAML config file")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective config with secrets redacted and exit")
+
+	flags := make(map[string]*settingFlag)
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		flags[s.flag] = &settingFlag{isBool: s.isBool}
+		fs.Var(flags[s.flag], s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.set(value); err != nil {
+				return cfg, false, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(flags[s.flag].value); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, false, flagErr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, false, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, printConfig, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Validate checks the config for values meeseeks can't start with.
+func (c *Config) Validate() error {
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port)
+	}
+
+	argoURL, err := url.Parse(c.ArgoCD.URL)
+	if err != nil || (argoURL.Scheme != "http" && argoURL.Scheme != "https") || argoURL.Host == "" {
+		return fmt.Errorf("argocd.url must be an http or https URL, got %q", c.ArgoCD.URL)
+	}
+	if c.ArgoCD.Token == "" && !c.MockMode() {
+		return fmt.Errorf("argocd.token (or ARGOCD_TOKEN) is required unless dev_mode is set")
+	}
+	if c.ArgoCD.Project == "" {
+		return fmt.Errorf("argocd.project cannot be empty")
+	}
+	if err := validateName(c.ArgoCD.Namespace); err != nil {
+		return fmt.Errorf("argocd.namespace: %w", err)
+	}
+	if c.ArgoCD.DestinationServer == "" {
+		return fmt.Errorf("argocd.destination_server cannot be empty")
+	}
+	if c.ArgoCD.RetryAttempts < 1 {
+		return fmt.Errorf("argocd.retry_attempts must be at least 1")
+	}
+	if c.ArgoCD.BreakerThreshold < 1 {
+		return fmt.Errorf("argocd.breaker_threshold must be at least 1")
+	}
+	if c.ArgoCD.BreakerCooldown <= 0 {
+		return fmt.Errorf("argocd.breaker_cooldown must be positive")
+	}
+	if c.ArgoCD.CallTimeout < 0 {
+		return fmt.Errorf("argocd.call_timeout cannot be negative")
+	}
+
+	if err := validateDependencies(c.Environments.AllowedDependencies); err != nil {
+		return fmt.Errorf("environments.allowed_dependencies: %w", err)
+	}
+	if len(c.Environments.Profiles) == 0 {
+		return fmt.Errorf("environments.profiles cannot be empty")
+	}
+	seen := make(map[string]bool, len(c.Environments.Profiles))
+	for i, profile := range c.Environments.Profiles {
+		if err := profile.validate(c.Environments.AllowedDependencies); err != nil {
+			return fmt.Errorf("environments.profiles[%d]: %w", i, err)
+		}
+		if seen[profile.Name] {
+			return fmt.Errorf("environments.profiles: duplicate profile %s", profile.Name)
+		}
+		seen[profile.Name] = true
+	}
+	if !seen[c.Environments.DefaultProfile] {
+		return fmt.Errorf("environments.default_profile: %q is not a profile", c.Environments.DefaultProfile)
+	}
+	if c.Environments.CredentialsImage == "" {
+		return fmt.Errorf("environments.credentials_image cannot be empty")
+	}
+
+	if err := c.Ingress.validate(); err != nil {
+		return fmt.Errorf("ingress.%w", err)
+	}
+
+	durations := map[string]time.Duration{
+		"server.read_header_timeout": c.Server.ReadHeaderTimeout,
+		"server.read_timeout":        c.Server.ReadTimeout,
+		"server.write_timeout":       c.Server.WriteTimeout,
+		"server.idle_timeout":        c.Server.IdleTimeout,
+		"server.shutdown_timeout":    c.Server.ShutdownTimeout,
+		"reaper.interval":            c.Reaper.Interval,
+		"events.interval":            c.Events.Interval,
+		"auth.session_ttl":           c.Auth.SessionTTL,
+	}
+	for name, d := range durations {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	if err := c.Quotas.Owner.validate(); err != nil {
+		return fmt.Errorf("quotas.owner: %w", err)
+	}
+	if err := c.Quotas.Team.validate(); err != nil {
+		return fmt.Errorf("quotas.team: %w", err)
+	}
+	for team, limits := range c.Quotas.Teams {
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("quotas.teams.%s: %w", team, err)
+		}
+	}
+
+	if oidc := c.Auth.OIDC; oidc.IssuerURL != "" {
+		if issuerURL, err := url.Parse(oidc.IssuerURL); err != nil || issuerURL.Scheme != "https" {
+			return fmt.Errorf("auth.oidc.issuer_url must be an https URL, got %q", oidc.IssuerURL)
+		}
+		if oidc.ClientID == "" {
+			return fmt.Errorf("auth.oidc.client_id is required with an issuer")
+		}
+		redirectURL, err := url.Parse(oidc.RedirectURL)
+		if err != nil || !strings.HasSuffix(redirectURL.Path, "/auth/callback") {
+			return fmt.Errorf("auth.oidc.redirect_url must be meeseeks' /auth/callback URL, got %q", oidc.RedirectURL)
+		}
+		if !slices.Contains(oidc.Scopes, "openid") {
+			return fmt.Errorf("auth.oidc.scopes must include openid")
+		}
+	}
+
+	return nil
+}
+
+func validateDomain(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("cannot be empty")
+	}
+	if len(domain) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", domain)
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if err := validateName(label); err != nil {
+			return fmt.Errorf("%q is not a valid domain: %w", domain, err)
+		}
+	}
+	return nil
+}
+
+// MockMode reports whether meeseeks should run against the in-memory mock:
+// in dev mode, or when the token is the "mock-token" placeholder.
+func (c *Config) MockMode() bool {
+	return c.DevMode || c.ArgoCD.Token == "mock-token"
+}
+
+// ApplicationSettings returns where and how Applications are rendered.
+func (c *Config) ApplicationSettings() ApplicationSettings {
+	return ApplicationSettings{
+		Project:           c.ArgoCD.Project,
+		Namespace:         c.ArgoCD.Namespace,
+		DestinationServer: c.ArgoCD.DestinationServer,
+		DefaultProfile:    c.Environments.DefaultProfile,
+		Profiles:          c.Environments.Profiles,
+		Ingress:           c.Ingress,
+		CredentialsImage:  c.Environments.CredentialsImage,
+	}
+}
+
+func (c *Config) RetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.MaxAttempts = c.ArgoCD.RetryAttempts
+	return policy
+}
+
+func (c *Config) ServerTimeouts() ServerTimeouts {
+	return ServerTimeouts{
+		ReadHeader: c.Server.ReadHeaderTimeout,
+		Read:       c.Server.ReadTimeout,
+		Write:      c.Server.WriteTimeout,
+		Idle:       c.Server.IdleTimeout,
+		Shutdown:   c.Server.ShutdownTimeout,
+	}
+}
+
+// ValidateRequest checks req against the dependencies this deployment allows
+// and its environment type's profile. req should already have its profile
+// and catalog defaults applied so the defaults are checked too.
+func (c *Config) ValidateRequest(req EnvironmentRequest) error {
+	var errs ValidationErrors
+
+	profile, ok := c.Environments.Profile(req.EnvType)
+	if !ok {
+		names := make([]string, len(c.Environments.Profiles))
+		for i, p := range c.Environments.Profiles {
+			names[i] = p.Name
+		}
+		errs.Add("env_type", invalid(codeNotAllowed, "%s is not allowed. Allowed: %s", req.EnvType, strings.Join(names, ", ")))
+	}
+
+	allowed := c.Environments.AllowedDependencies
+	if len(profile.AllowedDependencies) > 0 {
+		allowed = profile.AllowedDependencies
+	}
+	for i, dep := range req.Dependencies {
+		if !slices.Contains(allowed, dep) {
+			errs.Add(fmt.Sprintf("dependencies[%d]", i), invalid(codeNotAllowed, "%s is not allowed. Allowed: %s", dep, strings.Join(allowed, ", ")))
+		}
+	}
+
+	errs.Merge(profile.Bounds.Check(req))
+
+	return errs.Err()
+}
+
+// Redacted returns a copy of the config that is safe to print.
+func (c Config) Redacted() Config {
+	for _, secret := range []*string{&c.ArgoCD.Token, &c.Auth.SessionSecret, &c.Auth.OIDC.ClientSecret} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return c
+}
+
+// Print writes the config as YAML with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	return encoder.Close()
+}
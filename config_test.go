@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestArgoCDTokenAndMockMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		devMode  bool
+		token    string
+		wantErr  bool
+		wantMock bool
+	}{
+		{name: "token", token: "secret", wantMock: false},
+		{name: "no token", wantErr: true},
+		{name: "dev mode without token", devMode: true, wantMock: true},
+		{name: "dev mode with token", devMode: true, token: "secret", wantMock: true},
+		{name: "mock token", token: "mock-token", wantMock: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.DevMode = tt.devMode
+			cfg.ArgoCD.Token = tt.token
+
+			err := cfg.Validate()
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "argocd.token") {
+					t.Fatalf("Validate = %v, want an argocd.token error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if got := cfg.MockMode(); got != tt.wantMock {
+				t.Errorf("MockMode = %v, want %v", got, tt.wantMock)
+			}
+		})
+	}
+}
+
+func TestCredentialsImage(t *testing.T) {
+	env := map[string]string{"ARGOCD_TOKEN": "secret", "CREDENTIALS_IMAGE": "registry.example.com/tools/kubectl:1.33"}
+	cfg, _, err := LoadConfig(nil, func(key string) string { return env[key] }, io.Discard)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	client := NewArgoCDClient("http://argocd", "secret", DefaultCatalog()).WithApplicationSettings(cfg.ApplicationSettings())
+	app, err := client.buildApplication(EnvironmentRequest{Name: "creds", Branch: "main", Dependencies: []string{"postgresql"}})
+	if err != nil {
+		t.Fatalf("buildApplication: %v", err)
+	}
+	manifest, err := json.Marshal(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(manifest), `"image":"registry.example.com/tools/kubectl:1.33"`) {
+		t.Errorf("credentials Job doesn't use CREDENTIALS_IMAGE:\n%s", manifest)
+	}
+
+	cfg.Environments.CredentialsImage = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "credentials_image") {
+		t.Errorf("Validate = %v, want a credentials_image error", err)
+	}
+}
+
+func TestIngressConfigJSON(t *testing.T) {
+	cfg := DefaultIngressConfig()
+	cfg.ClusterIssuer = "letsencrypt"
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"controller_namespace"`, `"base_domain"`, `"host_template"`, `"cluster_issuer"`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("IngressConfig JSON %s has no %s key", data, key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+// Dependency passwords are generated inside the cluster so they never appear
+// in the Application spec, its history or its diffs. Each dependency that
+// uses authentication gets a PreSync hook Job that creates its Secret if it
+// doesn't exist yet. The Secret isn't part of the Application, so it
+// survives updates; it goes when the environment's namespace is deleted.
+
+const (
+	// defaultCredentialsImage provides kubectl, a POSIX shell and the
+	// coreutils the script uses. registry.k8s.io/kubectl has no shell.
+	defaultCredentialsImage = "alpine/k8s:1.33.1"
+	// credentialsJobUser is the unprivileged user the Jobs run as, since
+	// the image doesn't set one.
+	credentialsJobUser = 65534
+	// credentialsAccessName names the ServiceAccount, Role and RoleBinding
+	// the credentials Jobs run as.
+	credentialsAccessName = "meeseeks-credentials"
+)
+
+// credentialsScript creates $SECRET_NAME with a random password unless it
+// already exists. The password is hex, so it is safe to put in the URL
+// without escaping.
+const credentialsScript = `set -eu
+if kubectl get secret "$SECRET_NAME" >/dev/null 2>&1; then
+  echo "$SECRET_NAME already exists"
+  exit 0
+fi
+password=$(head -c 16 /dev/urandom | od -An -tx1 | tr -d ' \n')
+url=$(printf '%s' "$URL_TEMPLATE" | sed "s/{password}/$password/")
+kubectl create secret generic "$SECRET_NAME" \
+  --from-literal=username="$USERNAME" \
+  --from-literal=password="$password" \
+  --from-literal=url="$url"
+kubectl label secret "$SECRET_NAME" app="$DEPENDENCY" app.kubernetes.io/managed-by=meeseeks
+`
+
+// hookAnnotations makes a resource an Argo CD PreSync hook in the given
+// wave, replaced on every sync.
+func hookAnnotations(wave, deletePolicy string) map[string]string {
+	return map[string]string{
+		"argocd.argoproj.io/hook":               "PreSync",
+		"argocd.argoproj.io/hook-delete-policy": deletePolicy,
+		"argocd.argoproj.io/sync-wave":          wave,
+	}
+}
+
+// buildCredentialsAccess renders the ServiceAccount the credentials Jobs run
+// as, allowed to read and create Secrets in the environment's namespace
+// only. They are hooks in an earlier wave so they exist before the Jobs.
+func buildCredentialsAccess() []any {
+	meta := k8sObjectMeta{
+		Name:        credentialsAccessName,
+		Labels:      map[string]string{"app.kubernetes.io/managed-by": "meeseeks"},
+		Annotations: hookAnnotations("-1", "BeforeHookCreation"),
+	}
+
+	return []any{
+		k8sServiceAccount{
+			APIVersion: "v1",
+			Kind:       "ServiceAccount",
+			Metadata:   meta,
+		},
+		k8sRole{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "Role",
+			Metadata:   meta,
+			Rules: []k8sPolicyRule{{
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+				Verbs:     []string{"get", "create", "patch"},
+			}},
+		},
+		k8sRoleBinding{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "RoleBinding",
+			Metadata:   meta,
+			RoleRef: k8sRoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "Role",
+				Name:     credentialsAccessName,
+			},
+			Subjects: []k8sSubject{{Kind: "ServiceAccount", Name: credentialsAccessName}},
+		},
+	}
+}
+
+// buildCredentialsJob renders the Job that generates a dependency's Secret
+// using image. It meets the restricted Pod Security Standard.
+func buildCredentialsJob(dependency string, spec dependencySpec, image string) k8sJob {
+	secretName := dependencySecretName(dependency)
+	labels := map[string]string{
+		"app":                          dependency + "-credentials",
+		"app.kubernetes.io/managed-by": "meeseeks",
+	}
+	noEscalation := false
+
+	return k8sJob{
+		APIVersion: "batch/v1",
+		Kind:       "Job",
+		Metadata: k8sObjectMeta{
+			Name:        secretName,
+			Labels:      labels,
+			Annotations: hookAnnotations("0", "BeforeHookCreation,HookSucceeded"),
+		},
+		Spec: k8sJobSpec{
+			BackoffLimit: 3,
+			Template: k8sPodTemplate{
+				Metadata: k8sObjectMeta{Labels: labels},
+				Spec: k8sPodSpec{
+					ServiceAccountName: credentialsAccessName,
+					RestartPolicy:      "Never",
+					SecurityContext: &k8sPodSecurityContext{
+						RunAsNonRoot:   true,
+						RunAsUser:      credentialsJobUser,
+						SeccompProfile: &k8sSeccompProfile{Type: "RuntimeDefault"},
+					},
+					Containers: []k8sContainer{{
+						Name:    "credentials",
+						Image:   image,
+						Command: []string{"/bin/sh", "-c", credentialsScript},
+						Env: []envVar{
+							{Name: "SECRET_NAME", Value: secretName},
+							{Name: "DEPENDENCY", Value: dependency},
+							{Name: "USERNAME", Value: spec.Username},
+							{Name: "URL_TEMPLATE", Value: spec.connectionValue(dependency)},
+							{Name: "HOME", Value: "/tmp"},
+						},
+						Resources: &resourceRequirements{
+							Requests: map[string]string{"cpu": "10m", "memory": "32Mi"},
+							Limits:   map[string]string{"cpu": "100m", "memory": "64Mi"},
+						},
+						SecurityContext: &k8sSecurityContext{
+							AllowPrivilegeEscalation: &noEscalation,
+							Capabilities:             &k8sCapabilities{Drop: []string{"ALL"}},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Companion resources (dependencies and anything else meeseeks renders itself)
+// are shipped as a second Application source: a "raw" Helm chart whose only
+// job is to apply the manifests passed in its values. This keeps everything
+// in one Application without meeseeks needing write access to Git.
+const (
+	companionChartRepo    = "https://bedag.github.io/helm-charts/"
+	companionChart        = "raw"
+	companionChartVersion = "2.0.0"
+)
+
+// dependencySpec describes how a supported dependency is deployed and how the
+// app connects to it.
+type dependencySpec struct {
+	Image string
+	Port  int
+	Env   []envVar
+	// Username is set for dependencies that require authentication. A
+	// password is generated per environment in the cluster and both are
+	// stored in a Secret that the dependency reads through UsernameEnv and
+	// PasswordEnv.
+	Username    string
+	UsernameEnv string
+	PasswordEnv string
+	// DataPath is where the data volume is mounted. An empty DataPath means
+	// the dependency runs without a PVC.
+	DataPath    string
+	StorageSize string
+	// ConnectionEnv is injected into the app container. {host} and
+	// {username} in ConnectionValue are replaced when it is rendered, and
+	// {password} when the credentials Job creates the Secret.
+	ConnectionEnv   string
+	ConnectionValue string
+}
+
+var dependencySpecs = map[string]dependencySpec{
+	"postgresql": {
+		Image: "postgres:14",
+		Port:  5432,
+		Env: []envVar{
+			{Name: "POSTGRES_DB", Value: "myapp"},
+			{Name: "PGDATA", Value: "/var/lib/postgresql/data/pgdata"},
+		},
+		Username:        "app",
+		UsernameEnv:     "POSTGRES_USER",
+		PasswordEnv:     "POSTGRES_PASSWORD",
+		DataPath:        "/var/lib/postgresql/data",
+		StorageSize:     "1Gi",
+		ConnectionEnv:   "DATABASE_URL",
+		ConnectionValue: "postgres://{username}:{password}@{host}:5432/myapp?sslmode=disable",
+	},
+	"redis": {
+		Image:           "redis:7",
+		Port:            6379,
+		ConnectionEnv:   "REDIS_URL",
+		ConnectionValue: "redis://{host}:6379/0",
+	},
+	"mongodb": {
+		Image:           "mongo:6",
+		Port:            27017,
+		Username:        "root",
+		UsernameEnv:     "MONGO_INITDB_ROOT_USERNAME",
+		PasswordEnv:     "MONGO_INITDB_ROOT_PASSWORD",
+		DataPath:        "/data/db",
+		StorageSize:     "1Gi",
+		ConnectionEnv:   "MONGODB_URI",
+		ConnectionValue: "mongodb://{username}:{password}@{host}:27017/?authSource=admin",
+	},
+}
+
+func dependencySecretName(dependency string) string {
+	return dependency + "-credentials"
+}
+
+// DependencySecretNames returns the names of the Secrets created for the
+// given dependencies.
+func DependencySecretNames(dependencies []string) []string {
+	var names []string
+	for _, dep := range dependencies {
+		if dependencySpecs[dep].Username != "" {
+			names = append(names, dependencySecretName(dep))
+		}
+	}
+	return names
+}
+
+// connectionValue renders the app's connection setting for a dependency
+// reachable at host. {password} is left in place for the credentials Job to
+// fill in.
+func (spec dependencySpec) connectionValue(host string) string {
+	return strings.NewReplacer("{host}", host, "{username}", spec.Username).Replace(spec.ConnectionValue)
+}
+
+// buildDependencies renders the resources for every requested dependency,
+// plus the access the credentials Jobs need when any dependency uses
+// authentication. The Jobs run credentialsImage.
+func buildDependencies(dependencies []string, credentialsImage string) ([]any, error) {
+	var resources []any
+	if len(DependencySecretNames(dependencies)) > 0 {
+		resources = append(resources, buildCredentialsAccess()...)
+	}
+	for _, dep := range dependencies {
+		rendered, err := buildDependencyResources(dep, credentialsImage)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, rendered...)
+	}
+	return resources, nil
+}
+
+// buildDependencyResources renders the Deployment, Service and, for stateful
+// dependencies, the PVC for one dependency. Dependencies that use
+// authentication also get a Job that generates their Secret in the cluster.
+func buildDependencyResources(dependency, credentialsImage string) ([]any, error) {
+	spec, ok := dependencySpecs[dependency]
+	if !ok {
+		return nil, fmt.Errorf("unsupported dependency: %s", dependency)
+	}
+
+	labels := map[string]string{
+		"app":                          dependency,
+		"app.kubernetes.io/managed-by": "meeseeks",
+	}
+	selector := map[string]string{"app": dependency}
+
+	container := k8sContainer{
+		Name:  dependency,
+		Image: spec.Image,
+		Ports: []k8sContainerPort{{Name: dependency, ContainerPort: spec.Port}},
+		Env:   append([]envVar{}, spec.Env...),
+	}
+
+	deployment := k8sDeployment{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   k8sObjectMeta{Name: dependency, Labels: labels},
+		Spec: k8sDeploymentSpec{
+			Replicas: 1,
+			Selector: k8sLabelSelector{MatchLabels: selector},
+			Template: k8sPodTemplate{
+				Metadata: k8sObjectMeta{Labels: labels},
+			},
+		},
+	}
+
+	resources := []any{}
+
+	if spec.Username != "" {
+		secretName := dependencySecretName(dependency)
+		container.Env = append(container.Env,
+			secretEnvVar(spec.UsernameEnv, secretName, "username"),
+			secretEnvVar(spec.PasswordEnv, secretName, "password"),
+		)
+
+		resources = append(resources, buildCredentialsJob(dependency, spec, credentialsImage))
+	}
+
+	if spec.DataPath != "" {
+		claimName := dependency + "-data"
+		container.VolumeMounts = []k8sVolumeMount{{Name: "data", MountPath: spec.DataPath}}
+		deployment.Spec.Template.Spec.Volumes = []k8sVolume{{
+			Name:                  "data",
+			PersistentVolumeClaim: &k8sPVCVolumeSource{ClaimName: claimName},
+		}}
+		// A ReadWriteOnce volume can't be shared between the old and new pod
+		// during a rolling update.
+		deployment.Spec.Strategy = &k8sDeploymentStrategy{Type: "Recreate"}
+
+		resources = append(resources, k8sPersistentVolumeClaim{
+			APIVersion: "v1",
+			Kind:       "PersistentVolumeClaim",
+			Metadata:   k8sObjectMeta{Name: claimName, Labels: labels},
+			Spec: k8sPersistentVolumeClaimSpec{
+				AccessModes: []string{"ReadWriteOnce"},
+				Resources: resourceRequirements{
+					Requests: map[string]string{"storage": spec.StorageSize},
+				},
+			},
+		})
+	}
+
+	deployment.Spec.Template.Spec.Containers = []k8sContainer{container}
+
+	service := k8sService{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Metadata:   k8sObjectMeta{Name: dependency, Labels: labels},
+		Spec: k8sServiceSpec{
+			Selector: selector,
+			Ports:    []k8sServicePort{{Name: dependency, Port: spec.Port, TargetPort: spec.Port}},
+		},
+	}
+
+	return append(resources, deployment, service), nil
+}
+
+// dependencyEnvVars returns the connection env vars for the requested
+// dependencies. URLs that embed credentials are read from the dependency's
+// Secret rather than set inline.
+func dependencyEnvVars(dependencies []string) []envVar {
+	var env []envVar
+	for _, dep := range dependencies {
+		spec, ok := dependencySpecs[dep]
+		if !ok || spec.ConnectionEnv == "" {
+			continue
+		}
+		if spec.Username != "" {
+			env = append(env, secretEnvVar(spec.ConnectionEnv, dependencySecretName(dep), "url"))
+		} else {
+			env = append(env, envVar{Name: spec.ConnectionEnv, Value: spec.connectionValue(dep)})
+		}
+	}
+	return env
+}
+
+// buildCompanionSource wraps rendered manifests in a source for the raw chart.
+func buildCompanionSource(releaseName string, resources []any) ArgoCDApplicationSource {
+	return ArgoCDApplicationSource{
+		RepoURL:        companionChartRepo,
+		Chart:          companionChart,
+		TargetRevision: companionChartVersion,
+		Helm: &ArgoCDHelmSource{
+			ReleaseName: releaseName,
+			ValuesObject: map[string]any{
+				"resources": resources,
+			},
+		},
+	}
+}
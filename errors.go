@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gRPC status codes ArgoCD reports in the "code" field of its error bodies.
+const (
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcFailedPrecondition = 9
+	grpcAborted            = 10
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+var (
+	// ErrConflict is returned when an update races with another change to
+	// the same Application.
+	ErrConflict = errors.New("conflicting update")
+
+	// ErrNotManaged is returned for Applications that exist but weren't
+	// created by meeseeks.
+	ErrNotManaged = errors.New("not managed by meeseeks")
+
+	// ErrForbidden is returned when the caller may not change an
+	// environment.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// ArgoCDError is a non-success response from the ArgoCD API.
+type ArgoCDError struct {
+	StatusCode int
+	// Code is the gRPC status code from the error body, or 0 if the body
+	// couldn't be decoded.
+	Code    int
+	Message string
+}
+
+func (e *ArgoCDError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ArgoCD API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("ArgoCD API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// newArgoCDError builds an ArgoCDError from a response, decoding ArgoCD's
+// {"error", "code", "message"} body when there is one.
+func newArgoCDError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	argoErr := &ArgoCDError{StatusCode: resp.StatusCode}
+
+	var decoded struct {
+		Error   string `json:"error"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &decoded); err == nil {
+		argoErr.Code = decoded.Code
+		argoErr.Message = defaultIfEmpty(decoded.Message, decoded.Error)
+	} else {
+		argoErr.Message = strings.TrimSpace(string(body))
+	}
+
+	return argoErr
+}
+
+// httpStatusForError maps an error from the ArgoCD client to the status the
+// meeseeks API should respond with.
+func httpStatusForError(err error) int {
+	switch {
+	case errors.As(err, new(ValidationErrors)):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrConflict):
+		return http.StatusConflict
+	case errors.As(err, new(*QuotaExceededError)):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotManaged):
+		return http.StatusNotFound
+	case errors.Is(err, ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+
+	var argoErr *ArgoCDError
+	if !errors.As(err, &argoErr) {
+		return http.StatusInternalServerError
+	}
+
+	switch argoErr.Code {
+	case grpcNotFound:
+		return http.StatusNotFound
+	case grpcAlreadyExists, grpcAborted, grpcFailedPrecondition:
+		return http.StatusConflict
+	case grpcPermissionDenied:
+		return http.StatusForbidden
+	case grpcInvalidArgument:
+		// ArgoCD reports creating an app that already exists with a
+		// different spec as an invalid argument.
+		if strings.Contains(argoErr.Message, "existing application spec is different") {
+			return http.StatusConflict
+		}
+		return http.StatusUnprocessableEntity
+	case grpcUnauthenticated:
+		// meeseeks' own ArgoCD token was rejected; that's our problem, not
+		// the caller's.
+		return http.StatusBadGateway
+	case grpcUnavailable:
+		return http.StatusServiceUnavailable
+	}
+
+	switch argoErr.StatusCode {
+	case http.StatusNotFound, http.StatusConflict, http.StatusForbidden:
+		return argoErr.StatusCode
+	case http.StatusBadRequest:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+// writeError responds with err's message, prefixed for context, and the
+// status it maps to. ValidationErrors are written as a JSON body listing
+// each field instead.
+func writeError(w http.ResponseWriter, prefix string, err error) {
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(struct {
+			Errors ValidationErrors `json:"errors"`
+		}{validationErrs})
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("%s: %v", prefix, err), httpStatusForError(err))
+}
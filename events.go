@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of environment events.
+const (
+	eventSnapshot = "snapshot"
+	eventCreated  = "created"
+	eventChanged  = "changed"
+	eventDeleted  = "deleted"
+)
+
+const (
+	// eventBuffer is how many events a subscriber may fall behind by before
+	// it is dropped.
+	eventBuffer = 64
+	// eventKeepalive is how often an idle stream gets a comment, so proxies
+	// don't close it.
+	eventKeepalive = 30 * time.Second
+)
+
+// EnvironmentEvent is a change to an environment, or its current state when
+// a stream starts.
+type EnvironmentEvent struct {
+	ID      int               `json:"-"`
+	Type    string            `json:"type"`
+	Name    string            `json:"name"`
+	Changes []StatusChange    `json:"changes,omitempty"`
+	State   *EnvironmentState `json:"state,omitempty"`
+}
+
+// StatusChange is one status field that changed, on the environment or on
+// one of its resources.
+type StatusChange struct {
+	// Resource is "<kind>/<namespace>/<name>" for a resource's status and
+	// empty for the environment's own.
+	Resource string `json:"resource,omitempty"`
+	Field    string `json:"field"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+}
+
+// EnvironmentState is the part of an environment's live status that events
+// report.
+type EnvironmentState struct {
+	SyncStatus       string                   `json:"sync_status"`
+	HealthStatus     string                   `json:"health_status"`
+	HealthMessage    string                   `json:"health_message,omitempty"`
+	OperationPhase   string                   `json:"operation_phase,omitempty"`
+	OperationMessage string                   `json:"operation_message,omitempty"`
+	Revision         string                   `json:"revision,omitempty"`
+	URL              string                   `json:"url,omitempty"`
+	Resources        map[string]ResourceState `json:"resources,omitempty"`
+}
+
+// ResourceState is a managed resource's sync and health.
+type ResourceState struct {
+	SyncStatus   string `json:"sync_status,omitempty"`
+	HealthStatus string `json:"health_status,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+func environmentState(detail EnvironmentDetail) EnvironmentState {
+	state := EnvironmentState{
+		SyncStatus:    detail.SyncStatus,
+		HealthStatus:  detail.HealthStatus,
+		HealthMessage: detail.HealthMessage,
+		Revision:      detail.Revision,
+		URL:           detail.URL,
+		Resources:     make(map[string]ResourceState, len(detail.Resources)),
+	}
+	if op := detail.OperationState; op != nil {
+		state.OperationPhase = op.Phase
+		state.OperationMessage = op.Message
+	}
+	for _, resource := range detail.Resources {
+		rs := ResourceState{SyncStatus: resource.Status}
+		if resource.Health != nil {
+			rs.HealthStatus = resource.Health.Status
+			rs.Message = resource.Health.Message
+		}
+		state.Resources[resourceKey(resource)] = rs
+	}
+	return state
+}
+
+func resourceKey(resource ArgoCDResourceStatus) string {
+	return strings.Join([]string{resource.Kind, resource.Namespace, resource.Name}, "/")
+}
+
+// changes lists the status fields that differ between s and next. Messages
+// aren't compared; they ride along in the state.
+func (s EnvironmentState) changes(next EnvironmentState) []StatusChange {
+	var changes []StatusChange
+	compare := func(resource, field, from, to string) {
+		if from != to {
+			changes = append(changes, StatusChange{Resource: resource, Field: field, From: from, To: to})
+		}
+	}
+
+	compare("", "sync_status", s.SyncStatus, next.SyncStatus)
+	compare("", "health_status", s.HealthStatus, next.HealthStatus)
+	compare("", "operation_phase", s.OperationPhase, next.OperationPhase)
+	compare("", "revision", s.Revision, next.Revision)
+	compare("", "url", s.URL, next.URL)
+
+	keys := make(map[string]bool)
+	for key := range s.Resources {
+		keys[key] = true
+	}
+	for key := range next.Resources {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		from, to := s.Resources[key], next.Resources[key]
+		compare(key, "sync_status", from.SyncStatus, to.SyncStatus)
+		compare(key, "health_status", from.HealthStatus, to.HealthStatus)
+	}
+	return changes
+}
+
+// EnvironmentEvents polls ArgoCD while anyone is subscribed and publishes
+// the differences between polls.
+type EnvironmentEvents struct {
+	client   ArgoCDClientInterface
+	interval time.Duration
+
+	// poll serializes polls with new subscriptions, so a subscriber's
+	// snapshot and the events that follow it line up.
+	poll   sync.Mutex
+	states map[string]EnvironmentState
+
+	mu          sync.Mutex
+	subscribers map[*eventSubscription]bool
+	lastID      int
+	stopped     bool
+}
+
+type eventSubscription struct {
+	// name limits the subscription to one environment; empty means all.
+	name   string
+	events chan EnvironmentEvent
+}
+
+func NewEnvironmentEvents(client ArgoCDClientInterface, interval time.Duration) *EnvironmentEvents {
+	return &EnvironmentEvents{
+		client:      client,
+		interval:    interval,
+		subscribers: make(map[*eventSubscription]bool),
+	}
+}
+
+// Run polls once per interval until ctx is cancelled, then ends every
+// subscription.
+func (e *EnvironmentEvents) Run(ctx context.Context) {
+	defer e.stop()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Poll(ctx)
+		}
+	}
+}
+
+// Poll lists the environments and publishes how they changed since the last
+// poll. Nothing is polled while nobody is subscribed.
+func (e *EnvironmentEvents) Poll(ctx context.Context) {
+	e.poll.Lock()
+	defer e.poll.Unlock()
+
+	if !e.hasSubscribers() {
+		e.states = nil
+		return
+	}
+
+	next, err := e.list(ctx)
+	if err != nil {
+		log.Printf("Events: failed to list environments: %v", err)
+		return
+	}
+
+	names := make([]string, 0, len(next))
+	for name := range next {
+		names = append(names, name)
+	}
+	for name := range e.states {
+		if _, ok := next[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		previous, existed := e.states[name]
+		state, exists := next[name]
+		switch {
+		case !existed:
+			e.publish(EnvironmentEvent{Type: eventCreated, Name: name, State: &state})
+		case !exists:
+			e.publish(EnvironmentEvent{Type: eventDeleted, Name: name})
+		default:
+			if changes := previous.changes(state); len(changes) > 0 {
+				e.publish(EnvironmentEvent{Type: eventChanged, Name: name, Changes: changes, State: &state})
+			}
+		}
+	}
+
+	e.states = next
+}
+
+// Subscribe returns the current state of the named environment, or of every
+// environment if name is empty, as snapshot events, and a channel of the
+// events that follow. The channel is closed when the subscriber falls
+// behind or e stops; cancel ends the subscription.
+func (e *EnvironmentEvents) Subscribe(ctx context.Context, name string) (snapshot []EnvironmentEvent, events <-chan EnvironmentEvent, cancel func(), err error) {
+	e.poll.Lock()
+	defer e.poll.Unlock()
+
+	if e.states == nil {
+		states, err := e.list(ctx)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		e.states = states
+	}
+
+	names := make([]string, 0, len(e.states))
+	for n := range e.states {
+		if name == "" || n == name {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		state := e.states[n]
+		snapshot = append(snapshot, EnvironmentEvent{Type: eventSnapshot, Name: n, State: &state})
+	}
+
+	sub := &eventSubscription{name: name, events: make(chan EnvironmentEvent, eventBuffer)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.stopped {
+		close(sub.events)
+	} else {
+		e.subscribers[sub] = true
+	}
+
+	return snapshot, sub.events, func() { e.unsubscribe(sub) }, nil
+}
+
+func (e *EnvironmentEvents) list(ctx context.Context) (map[string]EnvironmentState, error) {
+	details, err := e.client.ListEnvironmentDetails(ctx)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]EnvironmentState, len(details))
+	for _, detail := range details {
+		states[detail.Name] = environmentState(detail)
+	}
+	return states, nil
+}
+
+func (e *EnvironmentEvents) hasSubscribers() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.subscribers) > 0
+}
+
+// publish sends event to every interested subscriber. Subscribers whose
+// buffer is full are dropped rather than holding up the others.
+func (e *EnvironmentEvents) publish(event EnvironmentEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastID++
+	event.ID = e.lastID
+
+	for sub := range e.subscribers {
+		if sub.name != "" && sub.name != event.Name {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Events: dropping a subscriber that fell behind")
+			delete(e.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (e *EnvironmentEvents) unsubscribe(sub *eventSubscription) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.subscribers[sub] {
+		delete(e.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (e *EnvironmentEvents) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.stopped = true
+	for sub := range e.subscribers {
+		delete(e.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// streamEvents writes events to w as Server-Sent Events until the client
+// goes away or the subscription ends. A stream for a single environment
+// also ends once it is deleted.
+func streamEvents(w http.ResponseWriter, r *http.Request, events *EnvironmentEvents, name string) {
+	snapshot, updates, cancel, err := events.Subscribe(r.Context(), name)
+	if err != nil {
+		writeError(w, "Failed to watch environments", err)
+		return
+	}
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop ingress-nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range snapshot {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			if name != "" && event.Type == eventDeleted {
+				rc.Flush()
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes event in the text/event-stream format, named by its
+// type.
+func writeEvent(w http.ResponseWriter, event EnvironmentEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if event.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
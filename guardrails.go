@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// Pod Security Standards levels, enforced through namespace labels.
+var podSecurityLevels = []string{"privileged", "baseline", "restricted"}
+
+// NamespaceProfile constrains an environment's namespace. Its ResourceQuota
+// is sized from the request, so an environment can run what it asked for
+// but no more.
+type NamespaceProfile struct {
+	// PodSecurity is the Pod Security Standards level enforced in the
+	// namespace. It defaults to baseline.
+	PodSecurity string `yaml:"pod_security,omitempty" json:"pod_security,omitempty"`
+	// ContainerDefaults are the LimitRange requests and limits of
+	// containers that set none, such as dependencies. The limits default to
+	// the requests.
+	ContainerDefaults ContainerResources `yaml:"container_defaults" json:"container_defaults"`
+	// Headroom is added to the quota for sidecars, hooks and other pods
+	// meeseeks doesn't know about.
+	Headroom ContainerResources `yaml:"headroom,omitempty" json:"headroom,omitempty"`
+}
+
+// ContainerResources are CPU and memory requests and limits.
+type ContainerResources struct {
+	CPU         string `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	Memory      string `yaml:"memory,omitempty" json:"memory,omitempty"`
+	CPULimit    string `yaml:"cpu_limit,omitempty" json:"cpu_limit,omitempty"`
+	MemoryLimit string `yaml:"memory_limit,omitempty" json:"memory_limit,omitempty"`
+}
+
+func (r ContainerResources) validate() error {
+	for field, value := range map[string]string{"cpu": r.CPU, "cpu_limit": r.CPULimit} {
+		if value != "" {
+			if err := validateCPU(value); err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+		}
+	}
+	for field, value := range map[string]string{"memory": r.Memory, "memory_limit": r.MemoryLimit} {
+		if value != "" {
+			if err := validateMemory(value); err != nil {
+				return fmt.Errorf("%s: %w", field, err)
+			}
+		}
+	}
+	if err := validateLimit(r.CPU, r.CPULimit); err != nil {
+		return fmt.Errorf("cpu_limit: %w", err)
+	}
+	if err := validateLimit(r.Memory, r.MemoryLimit); err != nil {
+		return fmt.Errorf("memory_limit: %w", err)
+	}
+	return nil
+}
+
+// withLimits returns r with unset limits defaulted to the requests.
+func (r ContainerResources) withLimits() ContainerResources {
+	r.CPULimit = defaultIfEmpty(r.CPULimit, r.CPU)
+	r.MemoryLimit = defaultIfEmpty(r.MemoryLimit, r.Memory)
+	return r
+}
+
+func (n NamespaceProfile) validate(bounds ResourceBounds) error {
+	if n.PodSecurity != "" && !slices.Contains(podSecurityLevels, n.PodSecurity) {
+		return fmt.Errorf("pod_security must be one of privileged, baseline or restricted")
+	}
+	if n.ContainerDefaults.CPU == "" || n.ContainerDefaults.Memory == "" {
+		return fmt.Errorf("container_defaults.cpu and container_defaults.memory are required")
+	}
+	if err := n.ContainerDefaults.validate(); err != nil {
+		return fmt.Errorf("container_defaults.%w", err)
+	}
+	defaults := n.ContainerDefaults.withLimits()
+	if err := bounds.Check(EnvironmentRequest{CPU: defaults.CPU, Memory: defaults.Memory, CPULimit: defaults.CPULimit, MemoryLimit: defaults.MemoryLimit}); err != nil {
+		return fmt.Errorf("container_defaults are outside the bounds: %w", err)
+	}
+	if err := n.Headroom.validate(); err != nil {
+		return fmt.Errorf("headroom.%w", err)
+	}
+	return nil
+}
+
+// buildNamespace renders req's namespace, labelled with the profile's Pod
+// Security level when it has a namespace section. Argo CD still creates the
+// namespace ahead of the PreSync hooks, but because it is also one of the
+// Application's resources, deleting the environment deletes the namespace
+// and everything left in it, such as the dependency Secrets.
+func buildNamespace(req EnvironmentRequest, profile Profile) k8sNamespace {
+	labels := map[string]string{"app.kubernetes.io/managed-by": "meeseeks"}
+	if profile.Namespace != nil {
+		level := defaultIfEmpty(profile.Namespace.PodSecurity, "baseline")
+		labels["pod-security.kubernetes.io/enforce"] = level
+		labels["pod-security.kubernetes.io/warn"] = level
+		labels["pod-security.kubernetes.io/audit"] = level
+	}
+
+	return k8sNamespace{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Metadata:   k8sObjectMeta{Name: environmentNamespace(req.Name), Labels: labels},
+	}
+}
+
+// buildGuardrails renders the ResourceQuota, LimitRange and NetworkPolicies
+// for req's namespace. req must have its defaults applied.
+func buildGuardrails(req EnvironmentRequest, profile Profile, ingressNamespace string) []any {
+	ns := *profile.Namespace
+	labels := map[string]string{"app.kubernetes.io/managed-by": "meeseeks"}
+
+	defaults := ns.ContainerDefaults.withLimits()
+	limits := k8sLimitRangeItem{
+		Type: "Container",
+		Default: map[string]string{
+			"cpu":    defaults.CPULimit,
+			"memory": defaults.MemoryLimit,
+		},
+		DefaultRequest: map[string]string{
+			"cpu":    defaults.CPU,
+			"memory": defaults.Memory,
+		},
+	}
+	if bounds := profile.Bounds; bounds.MaxCPU != "" || bounds.MaxMemory != "" {
+		limits.Max = map[string]string{}
+		if bounds.MaxCPU != "" {
+			limits.Max["cpu"] = bounds.MaxCPU
+		}
+		if bounds.MaxMemory != "" {
+			limits.Max["memory"] = bounds.MaxMemory
+		}
+	}
+
+	resources := []any{
+		k8sResourceQuota{
+			APIVersion: "v1",
+			Kind:       "ResourceQuota",
+			Metadata:   k8sObjectMeta{Name: "meeseeks-quota", Labels: labels},
+			Spec:       k8sResourceQuotaSpec{Hard: namespaceQuota(req, ns)},
+		},
+		k8sLimitRange{
+			APIVersion: "v1",
+			Kind:       "LimitRange",
+			Metadata:   k8sObjectMeta{Name: "meeseeks-limits", Labels: labels},
+			Spec:       k8sLimitRangeSpec{Limits: []k8sLimitRangeItem{limits}},
+		},
+	}
+
+	return append(resources, buildNetworkPolicies(req.Dependencies, ingressNamespace, labels)...)
+}
+
+// namespaceQuota sizes the namespace's ResourceQuota. It has room for the
+// app's replicas plus one more pod for rolling updates, one pod per
+// dependency at the container defaults, and the headroom, which also buys
+// one spare pod.
+func namespaceQuota(req EnvironmentRequest, ns NamespaceProfile) map[string]string {
+	defaults := ns.ContainerDefaults.withLimits()
+	appPods := int64(max(req.Replicas, 1) + 1)
+	deps := int64(len(req.Dependencies))
+
+	// The app's limits default to its requests, and both default to the
+	// LimitRange when unset.
+	appCPU := defaultIfEmpty(req.CPU, defaults.CPU)
+	appMemory := defaultIfEmpty(req.Memory, defaults.Memory)
+	appCPULimit := defaultIfEmpty(req.CPULimit, defaultIfEmpty(req.CPU, defaults.CPULimit))
+	appMemoryLimit := defaultIfEmpty(req.MemoryLimit, defaultIfEmpty(req.Memory, defaults.MemoryLimit))
+
+	total := func(app, dependency, headroom string) *big.Rat {
+		sum := new(big.Rat)
+		for _, term := range []struct {
+			quantity string
+			count    int64
+		}{{app, appPods}, {dependency, deps}, {headroom, 1}} {
+			if q, err := parseQuantity(term.quantity); err == nil {
+				sum.Add(sum, q.Mul(q, big.NewRat(term.count, 1)))
+			}
+		}
+		return sum
+	}
+
+	headroom := ns.Headroom.withLimits()
+	pvcs := 0
+	for _, dep := range req.Dependencies {
+		if dependencySpecs[dep].DataPath != "" {
+			pvcs++
+		}
+	}
+
+	return map[string]string{
+		"requests.cpu":           formatCPU(total(appCPU, defaults.CPU, headroom.CPU)),
+		"requests.memory":        formatMemory(total(appMemory, defaults.Memory, headroom.Memory)),
+		"limits.cpu":             formatCPU(total(appCPULimit, defaults.CPULimit, headroom.CPULimit)),
+		"limits.memory":          formatMemory(total(appMemoryLimit, defaults.MemoryLimit, headroom.MemoryLimit)),
+		"pods":                   fmt.Sprint(appPods + deps + 1),
+		"persistentvolumeclaims": fmt.Sprint(pvcs),
+	}
+}
+
+// buildNetworkPolicies denies all ingress to the namespace except from the
+// ingress controller to the app, and from the namespace's own pods to its
+// dependencies.
+func buildNetworkPolicies(dependencies []string, ingressNamespace string, labels map[string]string) []any {
+	policy := func(name string, selector k8sLabelSelector, from ...k8sNetworkPolicyPeer) k8sNetworkPolicy {
+		spec := k8sNetworkPolicySpec{
+			PodSelector: selector,
+			PolicyTypes: []string{"Ingress"},
+			Ingress:     []k8sNetworkPolicyIngressRule{},
+		}
+		if len(from) > 0 {
+			spec.Ingress = append(spec.Ingress, k8sNetworkPolicyIngressRule{From: from})
+		}
+		return k8sNetworkPolicy{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+			Metadata:   k8sObjectMeta{Name: name, Labels: labels},
+			Spec:       spec,
+		}
+	}
+
+	// Dependencies carry the managed-by label; the app's pods don't.
+	app := k8sLabelSelector{MatchExpressions: []k8sLabelSelectorRequirement{
+		{Key: "app.kubernetes.io/managed-by", Operator: "NotIn", Values: []string{"meeseeks"}},
+	}}
+
+	policies := []any{
+		policy("meeseeks-default-deny", k8sLabelSelector{}),
+		policy("meeseeks-allow-ingress-controller", app, k8sNetworkPolicyPeer{
+			NamespaceSelector: &k8sLabelSelector{MatchLabels: map[string]string{"kubernetes.io/metadata.name": ingressNamespace}},
+		}),
+	}
+
+	if len(dependencies) > 0 {
+		deps := k8sLabelSelector{MatchExpressions: []k8sLabelSelectorRequirement{
+			{Key: "app", Operator: "In", Values: dependencies},
+		}}
+		policies = append(policies, policy("meeseeks-allow-dependencies", deps, k8sNetworkPolicyPeer{
+			PodSelector: &k8sLabelSelector{},
+		}))
+	}
+
+	return policies
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IngressConfig describes how environments are reached from outside the
+// cluster.
+type IngressConfig struct {
+	// ControllerNamespace is where the ingress controller runs; each
+	// environment's NetworkPolicies admit traffic from it.
+	ControllerNamespace string `yaml:"controller_namespace" json:"controller_namespace"`
+	// BaseDomain is the domain environment hosts are built under.
+	BaseDomain string `yaml:"base_domain" json:"base_domain"`
+	// HostTemplate is the host an environment is served at. {name}, {app},
+	// {env_type} and {base_domain} are replaced; {name} is required so
+	// hosts are unique.
+	HostTemplate string `yaml:"host_template" json:"host_template"`
+	// ClassName is the IngressClass of generated Ingresses. Empty uses the
+	// cluster's default class.
+	ClassName string `yaml:"class_name" json:"class_name,omitempty"`
+	// ClusterIssuer is the cert-manager ClusterIssuer that issues each
+	// environment's certificate. Empty serves environments over plain
+	// HTTP.
+	ClusterIssuer string `yaml:"cluster_issuer" json:"cluster_issuer,omitempty"`
+	// Annotations are added to every generated Ingress, e.g. for the
+	// ingress controller.
+	Annotations map[string]string `yaml:"annotations" json:"annotations,omitempty"`
+}
+
+func DefaultIngressConfig() IngressConfig {
+	return IngressConfig{
+		ControllerNamespace: "ingress-nginx",
+		BaseDomain:          "dev.example.com",
+		HostTemplate:        "{name}.{base_domain}",
+		ClassName:           "nginx",
+	}
+}
+
+func (c IngressConfig) validate() error {
+	if err := validateName(c.ControllerNamespace); err != nil {
+		return fmt.Errorf("controller_namespace: %w", err)
+	}
+	if err := validateDomain(c.BaseDomain); err != nil {
+		return fmt.Errorf("base_domain: %w", err)
+	}
+	if !strings.Contains(c.HostTemplate, "{name}") {
+		return fmt.Errorf("host_template must contain {name}")
+	}
+	sample := c.Host(EnvironmentRequest{Name: "sample", App: "app", EnvType: "dev"})
+	if err := validateDomain(sample); err != nil {
+		return fmt.Errorf("host_template: %w", err)
+	}
+	if c.ClassName != "" {
+		if err := validateName(c.ClassName); err != nil {
+			return fmt.Errorf("class_name: %w", err)
+		}
+	}
+	return nil
+}
+
+// Host returns the host req's environment is served at.
+func (c IngressConfig) Host(req EnvironmentRequest) string {
+	return strings.NewReplacer(
+		"{name}", req.Name,
+		"{app}", req.App,
+		"{env_type}", req.EnvType,
+		"{base_domain}", c.BaseDomain,
+	).Replace(c.HostTemplate)
+}
+
+// URL returns the address req's environment is served at.
+func (c IngressConfig) URL(req EnvironmentRequest) string {
+	scheme := "http"
+	if c.ClusterIssuer != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Host(req))
+}
+
+// buildIngress renders the Ingress that routes req's host to the app's
+// Service. req must have its catalog app resolved.
+func buildIngress(req EnvironmentRequest, app CatalogApp, config IngressConfig) k8sIngress {
+	host := config.Host(req)
+
+	annotations := make(map[string]string, len(config.Annotations)+1)
+	for k, v := range config.Annotations {
+		annotations[k] = v
+	}
+
+	ingress := k8sIngress{
+		APIVersion: "networking.k8s.io/v1",
+		Kind:       "Ingress",
+		Metadata: k8sObjectMeta{
+			Name:        req.Name,
+			Labels:      map[string]string{"app.kubernetes.io/managed-by": "meeseeks"},
+			Annotations: annotations,
+		},
+		Spec: k8sIngressSpec{
+			Rules: []k8sIngressRule{{
+				Host: host,
+				HTTP: k8sHTTPIngressRuleValue{
+					Paths: []k8sHTTPIngressPath{{
+						Path:     "/",
+						PathType: "Prefix",
+						Backend: k8sIngressBackend{
+							Service: k8sIngressServiceBackend{
+								Name: app.serviceName(),
+								Port: k8sServiceBackendPort{Number: app.servicePort()},
+							},
+						},
+					}},
+				},
+			}},
+		},
+	}
+
+	if config.ClassName != "" {
+		ingress.Spec.IngressClassName = config.ClassName
+	}
+
+	if config.ClusterIssuer != "" {
+		annotations["cert-manager.io/cluster-issuer"] = config.ClusterIssuer
+		ingress.Spec.TLS = []k8sIngressTLS{{
+			Hosts:      []string{host},
+			SecretName: req.Name + "-tls",
+		}}
+	}
+
+	return ingress
+}
+
+// applicationURL is the first external URL ArgoCD reports for app, which it
+// reads from the app's Ingresses once they are synced. It is empty until
+// then.
+func applicationURL(app ArgoCDApplication) string {
+	if app.Status == nil || len(app.Status.Summary.ExternalURLs) == 0 {
+		return ""
+	}
+	return app.Status.Summary.ExternalURLs[0]
+}
+
+// companionIngressURLs lists the URLs of the Ingresses among app's companion
+// resources, the way ArgoCD derives its external URLs.
+func companionIngressURLs(app ArgoCDApplication) []string {
+	var urls []string
+	for _, source := range app.Spec.Sources {
+		if source.Chart != companionChart || source.Helm == nil {
+			continue
+		}
+		resources, _ := source.Helm.ValuesObject["resources"].([]any)
+		for _, raw := range resources {
+			resource, _ := raw.(map[string]any)
+			if resource["kind"] != "Ingress" {
+				continue
+			}
+			spec, _ := resource["spec"].(map[string]any)
+			tlsHosts := map[string]bool{}
+			tls, _ := spec["tls"].([]any)
+			for _, t := range tls {
+				entry, _ := t.(map[string]any)
+				hosts, _ := entry["hosts"].([]any)
+				for _, h := range hosts {
+					if host, ok := h.(string); ok {
+						tlsHosts[host] = true
+					}
+				}
+			}
+			rules, _ := spec["rules"].([]any)
+			for _, r := range rules {
+				rule, _ := r.(map[string]any)
+				host, _ := rule["host"].(string)
+				if host == "" {
+					continue
+				}
+				scheme := "http"
+				if tlsHosts[host] {
+					scheme = "https"
+				}
+				urls = append(urls, fmt.Sprintf("%s://%s/", scheme, host))
+			}
+		}
+	}
+	return urls
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval limits how often an unknown key ID makes the key set
+// be fetched again, so tokens naming made-up keys can't hammer the provider.
+const jwksRefreshInterval = time.Minute
+
+// signingAlgorithms are the JWS algorithms ID tokens may be signed with.
+var signingAlgorithms = map[string]struct {
+	hash  crypto.Hash
+	curve elliptic.Curve
+}{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"ES256": {hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+// JWKS is an OIDC provider's JSON Web Key Set. Keys are fetched on first use
+// and again when a token is signed with a key that isn't known yet, which is
+// how providers rotate keys.
+type JWKS struct {
+	url     string
+	client  *http.Client
+	now     func() time.Time
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewJWKS returns the key set at url. Nothing is fetched until a token is
+// verified.
+func NewJWKS(url string, client *http.Client) *JWKS {
+	return &JWKS{url: url, client: client, now: time.Now}
+}
+
+// Verify checks the signature of a compact JWS and returns its payload.
+func (k *JWKS) Verify(ctx context.Context, token string) ([]byte, error) {
+	header, payload, signature, err := splitJWS(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var h struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	alg, ok := signingAlgorithms[h.Alg]
+	if !ok {
+		return nil, fmt.Errorf("ID token is signed with unsupported algorithm %q", h.Alg)
+	}
+
+	key, err := k.key(ctx, h.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := alg.hash.New()
+	digest.Write([]byte(token[:strings.LastIndex(token, ".")]))
+	hashed := digest.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg.curve != nil || rsa.VerifyPKCS1v15(key, alg.hash, hashed, signature) != nil {
+			return nil, fmt.Errorf("ID token signature is invalid")
+		}
+	case *ecdsa.PublicKey:
+		size := (alg.curve.Params().BitSize + 7) / 8
+		if key.Curve != alg.curve || len(signature) != 2*size {
+			return nil, fmt.Errorf("ID token signature is invalid")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, hashed, r, s) {
+			return nil, fmt.Errorf("ID token signature is invalid")
+		}
+	default:
+		return nil, fmt.Errorf("ID token key %q has unsupported type %T", h.Kid, key)
+	}
+
+	return payload, nil
+}
+
+// key returns the key with the given ID, fetching the key set if it isn't
+// known. A token without a key ID may use the only key in the set.
+func (k *JWKS) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	if !k.fetched.IsZero() && k.now().Sub(k.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("ID token is signed with unknown key %q", kid)
+	}
+
+	keys, err := k.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k.keys = keys
+	k.fetched = k.now()
+
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ID token is signed with unknown key %q", kid)
+}
+
+func (k *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", k.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OIDC signing keys returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC signing keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys of types meeseeks can't verify with are skipped rather than
+		// failing the whole set.
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		// ECDH rejects points that aren't on the curve.
+		if _, err := key.ECDH(); err != nil {
+			return nil, fmt.Errorf("invalid EC key: %w", err)
+		}
+		return key, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+}
+
+// splitJWS decodes the three parts of a compact JWS.
+func splitJWS(token string) (header, payload, signature []byte, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, fmt.Errorf("malformed ID token")
+	}
+
+	if header, err = base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+	if payload, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed ID token payload: %w", err)
+	}
+	if signature, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+	return header, payload, signature, nil
+}
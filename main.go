@@ -1,65 +1,271 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type EnvironmentRequest struct {
-	Name         string            `json:"name"`
-	Branch       string            `json:"branch"`
+	Name   string `json:"name"`
+	App    string `json:"app"`
+	Branch string `json:"branch"`
+	// CPU and Memory are the container's resource requests. The limits
+	// default to the requests.
 	CPU          string            `json:"cpu"`
 	Memory       string            `json:"memory"`
+	CPULimit     string            `json:"cpu_limit,omitempty"`
+	MemoryLimit  string            `json:"memory_limit,omitempty"`
 	Replicas     int               `json:"replicas"`
 	Dependencies []string          `json:"dependencies"`
 	EnvType      string            `json:"env_type"`
 	EnvVars      map[string]string `json:"env_vars"`
+	// TTL is a duration such as "72h" after which the environment is
+	// deleted. ExpiresAt sets the deletion time directly and wins over TTL.
+	TTL       string     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Owner is the authenticated caller that created the environment. It is
+	// set by meeseeks and ignored in request bodies.
+	Owner string `json:"owner,omitempty"`
+	// Team is the group the environment counts against for team quotas. It
+	// defaults to the caller's first group.
+	Team string `json:"team,omitempty"`
+}
+
+// clone returns a copy of req that shares no slices, maps or pointers with
+// it, so decoding a PATCH body into the copy leaves req unchanged.
+func (req EnvironmentRequest) clone() EnvironmentRequest {
+	req.Dependencies = slices.Clone(req.Dependencies)
+	req.EnvVars = maps.Clone(req.EnvVars)
+	if req.ExpiresAt != nil {
+		expiresAt := *req.ExpiresAt
+		req.ExpiresAt = &expiresAt
+	}
+	return req
 }
 
 type EnvironmentResponse struct {
 	ID     string `json:"id"`
 	Status string `json:"status"`
 	URL    string `json:"url"`
+	// Secrets names the Secrets holding generated dependency credentials.
+	Secrets []string `json:"secrets,omitempty"`
+	// Wait is set for ?wait=true requests, whose Status is then the
+	// outcome of the wait.
+	Wait *WaitResult `json:"wait,omitempty"`
 }
 
 type ArgoCDClientInterface interface {
-	CreateApplication(req EnvironmentRequest) (string, error)
-	ListApplications() (EnvironmentList, error)
-	DeleteApplication(name string) error
+	CreateApplication(ctx context.Context, req EnvironmentRequest) (string, error)
+	ListApplications(ctx context.Context) (EnvironmentList, error)
+	ListEnvironmentDetails(ctx context.Context) ([]EnvironmentDetail, error)
+	GetApplication(ctx context.Context, name string) (EnvironmentDetail, error)
+	UpdateApplication(ctx context.Context, req EnvironmentRequest, resourceVersion string) error
+	DeleteApplication(ctx context.Context, name string) error
 }
 
 type MeeseeksAPI struct {
 	argoCDClient ArgoCDClientInterface
+	catalog      *Catalog
+	config       *Config
+	policy       *Policy
+	quotas       *Quotas
+	events       *EnvironmentEvents
+	now          func() time.Time
+}
+
+// circuitReporter is implemented by clients that guard ArgoCD with a circuit
+// breaker.
+type circuitReporter interface {
+	CircuitStatus() CircuitStatus
+}
+
+// healthz reports whether meeseeks is up and, for the real client, whether
+// ArgoCD calls are currently being short-circuited. It always returns 200 so
+// an ArgoCD outage doesn't get meeseeks restarted.
+func (api *MeeseeksAPI) healthz(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Status string         `json:"status"`
+		ArgoCD *CircuitStatus `json:"argocd,omitempty"`
+	}{Status: "ok"}
+
+	if reporter, ok := api.argoCDClient.(circuitReporter); ok {
+		circuit := reporter.CircuitStatus()
+		response.ArgoCD = &circuit
+		if circuit.State != circuitClosed {
+			response.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// environmentID returns the {name} segment of /environments/{name}[/...].
+func environmentID(r *http.Request) string {
+	envID, _ := environmentPath(r)
+	return envID
+}
+
+// environmentPath splits /environments/{name}[/{action}] into its name and
+// action segments.
+func environmentPath(r *http.Request) (name, action string) {
+	name, action, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/environments/"), "/")
+	return name, action
+}
+
+// serveEnvironment routes /environments/{name} and its actions. The action
+// is the segment after the name, so an environment may be named after one.
+func (api *MeeseeksAPI) serveEnvironment(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/environments/events" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		api.environmentEvents(w, r)
+		return
+	}
+
+	envID, action := environmentPath(r)
+	if envID == "" {
+		http.Error(w, "Environment ID is required", http.StatusBadRequest)
+		return
+	}
+	// The name ends up in ArgoCD URLs, so one no environment could have is
+	// turned away before it gets there.
+	if err := validateName(envID); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid environment ID: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "":
+	case "extend":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		api.extendEnvironment(w, r)
+		return
+	case "events":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		api.environmentEvents(w, r)
+		return
+	case "wait":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		api.waitEnvironment(w, r)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.Header.Get("HX-Request") == "true" {
+			api.getEnvironmentHTMX(w, r)
+		} else {
+			api.getEnvironment(w, r)
+		}
+	case http.MethodPut, http.MethodPatch:
+		api.updateEnvironment(w, r)
+	case http.MethodDelete:
+		if err := api.removeEnvironment(r, envID); err != nil {
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprintf(w, `<div class="error">Failed to delete environment: %s</div>`, template.HTMLEscapeString(err.Error()))
+			} else {
+				writeError(w, "Failed to delete environment", err)
+			}
+			return
+		}
+
+		if r.Header.Get("HX-Request") == "true" {
+			// Return empty content to remove the element from DOM
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
 }
 
 func (api *MeeseeksAPI) createEnvironment(w http.ResponseWriter, r *http.Request) {
+	timeout, wait, err := waitOptions(r)
+	if err != nil {
+		writeError(w, "Invalid wait", err)
+		return
+	}
+
 	var req EnvironmentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	req.Owner = ownerName(r.Context())
+	req = api.withProfile(req, true)
+
+	if err := api.validateRequest(req); err != nil {
+		writeError(w, "Invalid environment", err)
+		return
+	}
 
-	if err := ValidateEnvironmentRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	req, err = resolveExpiry(req, api.now())
+	if err != nil {
+		writeError(w, "Invalid environment", err)
+		return
+	}
+
+	req.Team, err = api.policy.Team(IdentityFrom(r.Context()), req.Team)
+	if err != nil {
+		writeError(w, "Failed to create environment", err)
 		return
 	}
 
-	envID, err := api.argoCDClient.CreateApplication(req)
+	release, err := api.quotas.Reserve(r.Context(), api.argoCDClient, req)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create environment: %v", err), http.StatusInternalServerError)
+		writeError(w, "Failed to create environment", err)
+		return
+	}
+
+	since := api.now()
+	envID, err := api.argoCDClient.CreateApplication(r.Context(), req)
+	release(err == nil)
+	if err != nil {
+		writeError(w, "Failed to create environment", err)
 		return
 	}
 
 	response := EnvironmentResponse{
-		ID:     envID,
-		Status: "creating",
-		URL:    fmt.Sprintf("https://%s.dev.example.com", req.Name),
+		ID:      envID,
+		Status:  "creating",
+		URL:     api.config.ApplicationSettings().URL(api.catalog.WithDefaults(req)),
+		Secrets: DependencySecretNames(req.Dependencies),
+	}
+
+	if wait {
+		api.awaitEnvironment(w, r, response, since, timeout)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -67,9 +273,9 @@ func (api *MeeseeksAPI) createEnvironment(w http.ResponseWriter, r *http.Request
 }
 
 func (api *MeeseeksAPI) listEnvironments(w http.ResponseWriter, r *http.Request) {
-	environments, err := api.argoCDClient.ListApplications()
+	environments, err := api.argoCDClient.ListApplications(r.Context())
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list environments: %v", err), http.StatusInternalServerError)
+		writeError(w, "Failed to list environments", err)
 		return
 	}
 
@@ -77,21 +283,293 @@ func (api *MeeseeksAPI) listEnvironments(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(environments)
 }
 
+func (api *MeeseeksAPI) getEnvironment(w http.ResponseWriter, r *http.Request) {
+	envID := environmentID(r)
+
+	detail, err := api.argoCDClient.GetApplication(r.Context(), envID)
+	if err != nil {
+		writeError(w, "Failed to get environment", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// updateEnvironment handles PUT, which replaces the environment's request, and
+// PATCH, which merges the body into the current request. Clients can pass the
+// resource_version they last read in If-Match to detect concurrent edits.
+func (api *MeeseeksAPI) updateEnvironment(w http.ResponseWriter, r *http.Request) {
+	envID := environmentID(r)
+
+	timeout, wait, err := waitOptions(r)
+	if err != nil {
+		writeError(w, "Invalid wait", err)
+		return
+	}
+
+	current, err := api.argoCDClient.GetApplication(r.Context(), envID)
+	if err != nil {
+		writeError(w, "Failed to get environment", err)
+		return
+	}
+
+	if err := api.authorize(r, current); err != nil {
+		writeError(w, "Not allowed to change environment", err)
+		return
+	}
+
+	resourceVersion := current.ResourceVersion
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		resourceVersion = strings.Trim(ifMatch, `"`)
+	}
+
+	var req EnvironmentRequest
+	if r.Method == http.MethodPatch {
+		req = current.Request.clone()
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name != "" && req.Name != envID {
+		writeError(w, "Invalid environment", ValidationErrors{{Field: "name", Code: codeImmutable, Message: "environment name cannot be changed"}})
+		return
+	}
+	req.Name = envID
+	req.Owner = current.Request.Owner
+	req.Team = current.Request.Team
+	req = api.withProfile(req, r.Method == http.MethodPut)
+
+	if err := api.validateRequest(req); err != nil {
+		writeError(w, "Invalid environment", err)
+		return
+	}
+
+	// A PATCH keeps the current expiry unless it changes ttl or expires_at;
+	// a new ttl counts from now.
+	ttlChanged := req.TTL != current.Request.TTL
+	expiryChanged := !sameTime(req.ExpiresAt, current.Request.ExpiresAt)
+	if r.Method == http.MethodPatch && ttlChanged && !expiryChanged {
+		req.ExpiresAt = nil
+	}
+	if r.Method == http.MethodPut || ttlChanged || expiryChanged {
+		if req, err = resolveExpiry(req, api.now()); err != nil {
+			writeError(w, "Invalid environment", err)
+			return
+		}
+	}
+
+	release, err := api.quotas.Reserve(r.Context(), api.argoCDClient, req)
+	if err != nil {
+		writeError(w, "Failed to update environment", err)
+		return
+	}
+
+	since := api.now()
+	err = api.argoCDClient.UpdateApplication(r.Context(), req, resourceVersion)
+	release(err == nil)
+	if err != nil {
+		writeError(w, "Failed to update environment", err)
+		return
+	}
+
+	response := EnvironmentResponse{
+		ID:      envID,
+		Status:  "updating",
+		URL:     api.config.ApplicationSettings().URL(api.catalog.WithDefaults(req)),
+		Secrets: DependencySecretNames(req.Dependencies),
+	}
+
+	if wait {
+		api.awaitEnvironment(w, r, response, since, timeout)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// waitEnvironment waits for an existing environment to become ready, turn
+// degraded or fail to sync.
+func (api *MeeseeksAPI) waitEnvironment(w http.ResponseWriter, r *http.Request) {
+	timeout, err := parseWaitTimeout(r)
+	if err != nil {
+		writeError(w, "Invalid wait", err)
+		return
+	}
+
+	api.awaitEnvironment(w, r, EnvironmentResponse{ID: environmentID(r)}, time.Time{}, timeout)
+}
+
+// awaitEnvironment waits for the environment in response and writes response
+// with the outcome. Status reconciled before since is ignored. The response
+// is 200 once the environment is ready, 424 if it degraded or failed to sync
+// and 504 if it was still settling after timeout.
+func (api *MeeseeksAPI) awaitEnvironment(w http.ResponseWriter, r *http.Request, response EnvironmentResponse, since time.Time, timeout time.Duration) {
+	extendWriteDeadline(w, timeout)
+
+	result, detail, err := waitForEnvironment(r.Context(), api.argoCDClient, response.ID, since, timeout, waitPollInterval)
+	if err != nil {
+		writeError(w, "Failed to wait for environment", err)
+		return
+	}
+
+	response.Status = result.Status
+	response.URL = defaultIfEmpty(detail.URL, response.URL)
+	response.Wait = &result
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(waitStatusCode(result))
+	json.NewEncoder(w).Encode(response)
+}
+
+// environmentEvents streams status changes as Server-Sent Events, for every
+// environment at /environments/events and for one at
+// /environments/{name}/events.
+func (api *MeeseeksAPI) environmentEvents(w http.ResponseWriter, r *http.Request) {
+	var name string
+	if r.URL.Path != "/environments/events" {
+		name = environmentID(r)
+		if _, err := api.argoCDClient.GetApplication(r.Context(), name); err != nil {
+			writeError(w, "Failed to get environment", err)
+			return
+		}
+	}
+
+	streamEvents(w, r, api.events, name)
+}
+
+// extendEnvironment pushes an environment's expiry back by the given TTL,
+// counting from the current expiry or from now if it has already passed.
+func (api *MeeseeksAPI) extendEnvironment(w http.ResponseWriter, r *http.Request) {
+	envID := environmentID(r)
+
+	var body struct {
+		TTL string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var errs ValidationErrors
+	errs.Add("ttl", validateTTL(body.TTL))
+	if err := errs.Err(); err != nil {
+		writeError(w, "Invalid ttl", err)
+		return
+	}
+	ttl, _ := time.ParseDuration(body.TTL)
+
+	current, err := api.argoCDClient.GetApplication(r.Context(), envID)
+	if err != nil {
+		writeError(w, "Failed to get environment", err)
+		return
+	}
+
+	if err := api.authorize(r, current); err != nil {
+		writeError(w, "Not allowed to change environment", err)
+		return
+	}
+
+	req := current.Request
+	expiresAt := extendExpiry(req.ExpiresAt, ttl, api.now())
+	req.ExpiresAt = &expiresAt
+
+	if err := api.argoCDClient.UpdateApplication(r.Context(), req, current.ResourceVersion); err != nil {
+		writeError(w, "Failed to extend environment", err)
+		return
+	}
+
+	log.Printf("Extended environment %s until %s", envID, expiresAt.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":         envID,
+		"expires_at": expiresAt,
+	})
+}
+
 func (api *MeeseeksAPI) deleteEnvironment(w http.ResponseWriter, r *http.Request) {
-	envID := r.URL.Path[len("/environments/"):]
+	envID := environmentID(r)
 	if envID == "" {
 		http.Error(w, "Environment ID is required", http.StatusBadRequest)
 		return
 	}
 
-	if err := api.argoCDClient.DeleteApplication(envID); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to delete environment: %v", err), http.StatusInternalServerError)
+	if err := api.removeEnvironment(r, envID); err != nil {
+		writeError(w, "Failed to delete environment", err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// withProfile gives req the default environment type if it has none and
+// fills in its profile's defaults. An unknown type is left for validation to
+// report.
+func (api *MeeseeksAPI) withProfile(req EnvironmentRequest, withTTL bool) EnvironmentRequest {
+	profile, ok := api.config.Environments.Profile(req.EnvType)
+	if !ok {
+		return req
+	}
+	req.EnvType = profile.Name
+	return profile.withDefaults(req, withTTL)
+}
+
+// validateRequest runs the request, catalog and config checks and returns
+// every problem they find as ValidationErrors, one per field.
+func (api *MeeseeksAPI) validateRequest(req EnvironmentRequest) error {
+	var errs ValidationErrors
+	errs.Merge(ValidateEnvironmentRequest(req))
+	errs.Merge(api.catalog.ValidateRequest(req))
+	errs.Merge(api.config.ValidateRequest(api.catalog.WithDefaults(req)))
+	return errs.Err()
+}
+
+// authorize checks that the caller may change env.
+func (api *MeeseeksAPI) authorize(r *http.Request, env EnvironmentDetail) error {
+	return api.policy.Authorize(IdentityFrom(r.Context()), env.Request.Owner)
+}
+
+// removeEnvironment deletes envID after checking the caller owns it.
+func (api *MeeseeksAPI) removeEnvironment(r *http.Request, envID string) error {
+	current, err := api.argoCDClient.GetApplication(r.Context(), envID)
+	if err != nil {
+		return err
+	}
+
+	if err := api.authorize(r, current); err != nil {
+		return err
+	}
+
+	return api.argoCDClient.DeleteApplication(r.Context(), envID)
+}
+
+// myQuotas reports the caller's usage against their own and their teams'
+// quotas.
+func (api *MeeseeksAPI) myQuotas(w http.ResponseWriter, r *http.Request) {
+	identity := IdentityFrom(r.Context())
+	if identity == nil {
+		http.Error(w, "Quotas are tracked per user and authentication is disabled", http.StatusNotFound)
+		return
+	}
+
+	environments, err := api.argoCDClient.ListApplications(r.Context())
+	if err != nil {
+		writeError(w, "Failed to list environments", err)
+		return
+	}
+
+	response := struct {
+		Quotas []QuotaUsage `json:"quotas"`
+	}{api.quotas.Usage(environments.Items, identity)}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
 	tmpl := `<!DOCTYPE html>
 <html>
@@ -100,6 +578,7 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <script src="https://unpkg.com/htmx.org@1.9.10"></script>
+    <script src="https://unpkg.com/htmx.org@1.9.10/dist/ext/sse.js"></script>
     <style>
         body { 
             font-family: system-ui, -apple-system, sans-serif; 
@@ -119,6 +598,12 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
             margin-bottom: 30px;
             text-align: center;
         }
+        .user { 
+            text-align: right; 
+            color: #666; 
+            font-size: 14px;
+            margin: -20px 0 20px;
+        }
         .form-group { 
             margin-bottom: 15px; 
         }
@@ -155,6 +640,14 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
         .delete-btn:hover { 
             background: #c82333; 
         }
+        .details-btn { 
+            background: #6c757d; 
+            padding: 6px 12px;
+            font-size: 12px;
+        }
+        .details-btn:hover { 
+            background: #5a6268; 
+        }
         .environments { 
             margin-top: 30px; 
         }
@@ -199,58 +692,102 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
             color: #721c24; 
             border: 1px solid #f5c6cb;
         }
+        .field-error { 
+            color: #dc3545; 
+            font-size: 13px;
+            margin-top: 4px;
+        }
+        .form-group:has(.field-error:not(:empty)) :is(input, select, textarea) { 
+            border-color: #dc3545; 
+        }
     </style>
 </head>
 <body>
     <div class="container">
         <h1>🧪 Meeseeks Environment Manager</h1>
+        {{if .User}}<div class="user">Signed in as {{.User.Name}}{{if eq .User.Method "oidc"}} · <a href="/auth/logout">Log out</a>{{end}}</div>{{end}}
         
-        <form hx-post="/environments" hx-target="#response" hx-trigger="submit">
+        <form hx-post="/environments" hx-target="#response" hx-trigger="submit" hx-on::before-request="this.querySelectorAll('.field-error').forEach(e => e.textContent = '')">
             <div class="form-row">
                 <div class="form-group">
                     <label for="name">Environment Name:</label>
                     <input type="text" id="name" name="name" required>
+                    <div class="field-error" id="name-error"></div>
                 </div>
                 <div class="form-group">
                     <label for="branch">Branch:</label>
                     <input type="text" id="branch" name="branch" required>
+                    <div class="field-error" id="branch-error"></div>
                 </div>
             </div>
             
+            <div class="form-group">
+                <label for="app">Application:</label>
+                <select id="app" name="app" required>
+                    {{range .Apps}}<option value="{{.Name}}"{{if eq .Name $.Default}} selected{{end}}>{{.Name}}</option>
+                    {{end}}
+                </select>
+                <div class="field-error" id="app-error"></div>
+            </div>
+
             <div class="form-row">
                 <div class="form-group">
-                    <label for="cpu">CPU:</label>
-                    <input type="text" id="cpu" name="cpu" value="100m" required>
+                    <label for="cpu">CPU (optional):</label>
+                    <input type="text" id="cpu" name="cpu" placeholder="Profile or app default">
+                    <div class="field-error" id="cpu-error"></div>
                 </div>
                 <div class="form-group">
-                    <label for="memory">Memory:</label>
-                    <input type="text" id="memory" name="memory" value="256Mi" required>
+                    <label for="memory">Memory (optional):</label>
+                    <input type="text" id="memory" name="memory" placeholder="Profile or app default">
+                    <div class="field-error" id="memory-error"></div>
                 </div>
             </div>
             
             <div class="form-row">
                 <div class="form-group">
-                    <label for="replicas">Replicas:</label>
-                    <input type="number" id="replicas" name="replicas" value="1" min="1" required>
+                    <label for="cpu_limit">CPU Limit (optional):</label>
+                    <input type="text" id="cpu_limit" name="cpu_limit" placeholder="Same as CPU">
+                    <div class="field-error" id="cpu_limit-error"></div>
+                </div>
+                <div class="form-group">
+                    <label for="memory_limit">Memory Limit (optional):</label>
+                    <input type="text" id="memory_limit" name="memory_limit" placeholder="Same as memory">
+                    <div class="field-error" id="memory_limit-error"></div>
+                </div>
+            </div>
+            
+            <div class="form-row">
+                <div class="form-group">
+                    <label for="replicas">Replicas (optional):</label>
+                    <input type="number" id="replicas" name="replicas" min="1" placeholder="Profile or app default">
+                    <div class="field-error" id="replicas-error"></div>
                 </div>
                 <div class="form-group">
                     <label for="env_type">Environment Type:</label>
                     <select id="env_type" name="env_type" required>
-                        <option value="development">Development</option>
-                        <option value="staging">Staging</option>
-                        <option value="testing">Testing</option>
+                        {{range .Profiles}}<option value="{{.Name}}"{{if eq .Name $.DefaultProfile}} selected{{end}}>{{.DisplayName}}</option>
+                        {{end}}
                     </select>
+                    <div class="field-error" id="env_type-error"></div>
                 </div>
             </div>
             
+            <div class="form-group">
+                <label for="ttl">Time to live (e.g. 72h, empty for the profile default):</label>
+                <input type="text" id="ttl" name="ttl" placeholder="72h">
+                <div class="field-error" id="ttl-error"></div>
+            </div>
+
             <div class="form-group">
                 <label for="dependencies">Dependencies (comma-separated):</label>
                 <input type="text" id="dependencies" name="dependencies" placeholder="postgresql,redis">
+                <div class="field-error" id="dependencies-error"></div>
             </div>
             
             <div class="form-group">
                 <label for="env_vars">Environment Variables (JSON format):</label>
                 <textarea id="env_vars" name="env_vars" rows="3" placeholder='{"KEY1": "value1", "KEY2": "value2"}'>{}</textarea>
+                <div class="field-error" id="env_vars-error"></div>
             </div>
             
             <button type="submit">Create Environment</button>
@@ -261,8 +798,11 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
         
         <div class="environments">
             <h2>Environments</h2>
-            <div id="environments" hx-get="/environments" hx-trigger="load">
-                Loading environments...
+            <!-- Cards are re-rendered whenever an environment's status changes. -->
+            <div hx-ext="sse" sse-connect="/environments/events">
+                <div id="environments" hx-get="/environments" hx-trigger="load, sse:created, sse:changed throttle:1s, sse:deleted">
+                    Loading environments...
+                </div>
             </div>
         </div>
     </div>
@@ -275,14 +815,21 @@ func (api *MeeseeksAPI) serveHome(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	data := struct {
+		*Catalog
+		User           *Identity
+		Profiles       []Profile
+		DefaultProfile string
+	}{api.catalog, IdentityFrom(r.Context()), api.config.Environments.Profiles, api.config.Environments.DefaultProfile}
+
 	w.Header().Set("Content-Type", "text/html")
-	t.Execute(w, nil)
+	t.Execute(w, data)
 }
 
 func (api *MeeseeksAPI) createEnvironmentHTMX(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<div class="response error">Error parsing form: %v</div>`, err)
+		fmt.Fprintf(w, `<div class="response error">Error parsing form: %s</div>`, template.HTMLEscapeString(err.Error()))
 		return
 	}
 
@@ -303,20 +850,23 @@ func (api *MeeseeksAPI) createEnvironmentHTMX(w http.ResponseWriter, r *http.Req
 		envVarsStr = "{}"
 	}
 	if err := json.Unmarshal([]byte(envVarsStr), &envVars); err != nil {
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<div class="response error">Invalid environment variables JSON: %v</div>`, err)
+		writeValidationErrorsHTMX(w, ValidationErrors{{Field: "env_vars", Code: codeInvalidFormat, Message: fmt.Sprintf("invalid JSON: %v", err)}})
 		return
 	}
 
 	req := EnvironmentRequest{
 		Name:         r.FormValue("name"),
+		App:          r.FormValue("app"),
 		Branch:       r.FormValue("branch"),
 		CPU:          r.FormValue("cpu"),
 		Memory:       r.FormValue("memory"),
-		Replicas:     1,
+		CPULimit:     r.FormValue("cpu_limit"),
+		MemoryLimit:  r.FormValue("memory_limit"),
 		Dependencies: dependencies,
 		EnvType:      r.FormValue("env_type"),
 		EnvVars:      envVars,
+		TTL:          r.FormValue("ttl"),
+		Owner:        ownerName(r.Context()),
 	}
 
 	// Parse replicas
@@ -326,23 +876,51 @@ func (api *MeeseeksAPI) createEnvironmentHTMX(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	if err := ValidateEnvironmentRequest(req); err != nil {
+	req = api.withProfile(req, true)
+
+	if err := api.validateRequest(req); err != nil {
+		writeValidationErrorsHTMX(w, err)
+		return
+	}
+
+	req, err := resolveExpiry(req, api.now())
+	if err != nil {
+		writeValidationErrorsHTMX(w, err)
+		return
+	}
+
+	req.Team, err = api.policy.Team(IdentityFrom(r.Context()), "")
+	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<div class="response error">Validation error: %v</div>`, err)
+		fmt.Fprintf(w, `<div class="response error">Failed to create environment: %s</div>`, template.HTMLEscapeString(err.Error()))
 		return
 	}
 
-	envID, err := api.argoCDClient.CreateApplication(req)
+	release, err := api.quotas.Reserve(r.Context(), api.argoCDClient, req)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<div class="response error">Failed to create environment: %v</div>`, err)
+		fmt.Fprintf(w, `<div class="response error">Failed to create environment: %s</div>`, template.HTMLEscapeString(err.Error()))
+		return
+	}
+
+	envID, err := api.argoCDClient.CreateApplication(r.Context(), req)
+	release(err == nil)
+	if err != nil {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<div class="response error">Failed to create environment: %s</div>`, template.HTMLEscapeString(err.Error()))
 		return
 	}
 
 	response := EnvironmentResponse{
-		ID:     envID,
-		Status: "creating",
-		URL:    fmt.Sprintf("https://%s.dev.example.com", req.Name),
+		ID:      envID,
+		Status:  "creating",
+		URL:     api.config.ApplicationSettings().URL(api.catalog.WithDefaults(req)),
+		Secrets: DependencySecretNames(req.Dependencies),
+	}
+
+	secrets := "none"
+	if len(response.Secrets) > 0 {
+		secrets = strings.Join(response.Secrets, ", ")
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -350,15 +928,59 @@ func (api *MeeseeksAPI) createEnvironmentHTMX(w http.ResponseWriter, r *http.Req
 		<strong>Environment Created!</strong><br>
 		ID: %s<br>
 		Status: %s<br>
-		URL: <a href="%s" target="_blank">%s</a>
-	</div>`, response.ID, response.Status, response.URL, response.URL)
+		URL: %s<br>
+		Secrets: %s
+	</div>`, response.ID, response.Status, urlLink(response.URL, "none"), secrets)
+}
+
+// urlLink renders an environment URL as a link, or empty when there is none.
+func urlLink(url, empty string) string {
+	if url == "" {
+		return empty
+	}
+	escaped := template.HTMLEscapeString(url)
+	return fmt.Sprintf(`<a href="%s" target="_blank">%s</a>`, escaped, escaped)
+}
+
+// formFields are the inputs of the create form that have an error
+// placeholder.
+var formFields = []string{"name", "branch", "app", "cpu", "memory", "cpu_limit", "memory_limit", "replicas", "env_type", "ttl", "dependencies", "env_vars"}
+
+// writeValidationErrorsHTMX lists the validation errors in the response area
+// and swaps each message in under its form field, which highlights it.
+func writeValidationErrorsHTMX(w http.ResponseWriter, err error) {
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		errs = ValidationErrors{{Code: codeInvalidFormat, Message: err.Error()}}
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, `<div class="response error"><strong>Validation failed</strong><ul>`)
+	for _, fe := range errs {
+		fmt.Fprintf(w, `<li>%s</li>`, template.HTMLEscapeString(fe.Error()))
+	}
+	fmt.Fprintf(w, `</ul></div>`)
+
+	// Field paths like "dependencies[1]" belong to the dependencies input.
+	// The first message for each input wins.
+	shown := map[string]bool{}
+	for _, fe := range errs {
+		input, _, _ := strings.Cut(fe.Field, "[")
+		input, _, _ = strings.Cut(input, ".")
+		if !slices.Contains(formFields, input) || shown[input] {
+			continue
+		}
+		shown[input] = true
+		fmt.Fprintf(w, `<div class="field-error" id="%s-error" hx-swap-oob="true">%s</div>`,
+			template.HTMLEscapeString(input), template.HTMLEscapeString(fe.Message))
+	}
 }
 
 func (api *MeeseeksAPI) listEnvironmentsHTMX(w http.ResponseWriter, r *http.Request) {
-	environments, err := api.argoCDClient.ListApplications()
+	environments, err := api.argoCDClient.ListApplications(r.Context())
 	if err != nil {
 		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<div class="error">Failed to list environments: %v</div>`, err)
+		fmt.Fprintf(w, `<div class="error">Failed to list environments: %s</div>`, template.HTMLEscapeString(err.Error()))
 		return
 	}
 
@@ -369,90 +991,150 @@ func (api *MeeseeksAPI) listEnvironmentsHTMX(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	identity := IdentityFrom(r.Context())
 	for _, env := range environments.Items {
+		expires := "never"
+		if env.ExpiresAt != nil {
+			expires = env.ExpiresAt.Format(time.RFC3339)
+		}
+
+		owner := defaultIfEmpty(env.Owner, "unknown")
+
+		deleteButton := ""
+		if api.policy.Authorize(identity, env.Owner) == nil {
+			deleteButton = fmt.Sprintf(`
+					<button class="delete-btn" 
+						hx-delete="/environments/%s" 
+						hx-target="closest .env-item"
+						hx-confirm="Are you sure you want to delete this environment?">
+						Delete
+					</button>`, env.Name)
+		}
+
 		fmt.Fprintf(w, `
 		<div class="env-item">
 			<div class="env-header">
 				<div>
 					<div class="env-name">%s</div>
-					<div class="env-details">Status: %s</div>
+					<div class="env-details">Status: %s | Owner: %s | Expires: %s</div>
+				</div>
+				<div>
+					<button class="details-btn"
+						hx-get="/environments/%s"
+						hx-target="next .env-detail">
+						Details
+					</button>%s
 				</div>
-				<button class="delete-btn" 
-					hx-delete="/environments/%s" 
-					hx-target="closest .env-item"
-					hx-confirm="Are you sure you want to delete this environment?">
-					Delete
-				</button>
 			</div>
-		</div>`, env.Name, env.Status, env.Name)
+			<div class="env-detail"></div>
+		</div>`, env.Name, template.HTMLEscapeString(env.Status), template.HTMLEscapeString(owner), expires, env.Name, deleteButton)
 	}
 }
 
-// Mock ArgoCD client for development
-type MockArgoCDClient struct{}
+// environmentDetailTemplate renders the Details fragment. The request and
+// ArgoCD's messages come from users and the cluster, so they go through
+// html/template's escaping.
+var environmentDetailTemplate = template.Must(template.New("detail").Parse(`
+		<div class="env-details">
+			App: {{.Request.App}} | Branch: {{.Request.Branch}} | Type: {{.Request.EnvType}}<br>
+			Sync: {{.SyncStatus}} | Health: {{.HealthStatus}} | Revision: {{.Revision}}<br>
+			URL: {{if .URL}}<a href="{{.URL}}" target="_blank">{{.URL}}</a>{{else}}not available until the Ingress is synced{{end}}
+			{{- with .OperationState}}<br>Operation: {{.Phase}} {{.Message}}{{end}}
+			{{- range .Resources}}<br>&nbsp;&nbsp;{{.Kind}}/{{.Name}}: {{.Status}} {{with .Health}}{{.Status}}{{end}}{{end}}
+			{{- range .Conditions}}<br><span class="error">{{.Type}}: {{.Message}}</span>{{end}}
+		</div>`))
 
-func (m *MockArgoCDClient) CreateApplication(req EnvironmentRequest) (string, error) {
-	log.Printf("Mock: Creating environment %s", req.Name)
-	return req.Name, nil
-}
+func (api *MeeseeksAPI) getEnvironmentHTMX(w http.ResponseWriter, r *http.Request) {
+	envID := environmentID(r)
 
-func (m *MockArgoCDClient) ListApplications() (EnvironmentList, error) {
-	log.Printf("Mock: Listing applications")
-	return EnvironmentList{
-		Items: []EnvironmentItem{
-			{
-				ID:     "test-env-1",
-				Name:   "test-env-1",
-				Status: "Healthy",
-				URL:    "https://test-env-1.dev.example.com",
-			},
-			{
-				ID:     "staging-app",
-				Name:   "staging-app",
-				Status: "Progressing",
-				URL:    "https://staging-app.dev.example.com",
-			},
-			{
-				ID:     "demo-service",
-				Name:   "demo-service",
-				Status: "Healthy",
-				URL:    "https://demo-service.dev.example.com",
-			},
-		},
-	}, nil
-}
+	detail, err := api.argoCDClient.GetApplication(r.Context(), envID)
+	if err != nil {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<div class="error">Failed to get environment: %s</div>`, template.HTMLEscapeString(err.Error()))
+		return
+	}
 
-func (m *MockArgoCDClient) DeleteApplication(name string) error {
-	log.Printf("Mock: Deleting application %s", name)
-	return nil
+	w.Header().Set("Content-Type", "text/html")
+	if err := environmentDetailTemplate.Execute(w, detail); err != nil {
+		log.Printf("Failed to render environment %s: %v", envID, err)
+	}
 }
 
 func main() {
-	argoCDURL := os.Getenv("ARGOCD_URL")
-	if argoCDURL == "" {
-		argoCDURL = "http://localhost:30080"
+	cfg, printConfig, err := LoadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	argoCDToken := os.Getenv("ARGOCD_TOKEN")
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	catalog := DefaultCatalog()
+	if cfg.CatalogFile != "" {
+		catalog, err = LoadCatalog(cfg.CatalogFile)
+		if err != nil {
+			log.Fatalf("Failed to load catalog: %v", err)
+		}
+		log.Printf("Loaded %d apps from catalog %s", len(catalog.Apps), cfg.CatalogFile)
+	}
 
 	var client ArgoCDClientInterface
 
 	// Check if running in development mode
-	if argoCDToken == "" || argoCDToken == "mock-token" || os.Getenv("DEV_MODE") == "true" {
+	if cfg.MockMode() {
 		log.Println("🚀 Starting Meeseeks in Development Mode (Mock ArgoCD)")
 		log.Println("💡 Running in mock mode - no real ArgoCD calls will be made")
-		client = &MockArgoCDClient{}
+		client = NewMockArgoCDClient(catalog).
+			WithApplicationSettings(cfg.ApplicationSettings())
 	} else {
-		client = NewArgoCDClient(argoCDURL, argoCDToken)
+		client = NewArgoCDClient(cfg.ArgoCD.URL, cfg.ArgoCD.Token, catalog).
+			WithApplicationSettings(cfg.ApplicationSettings()).
+			WithRetryPolicy(cfg.RetryPolicy()).
+			WithCircuitBreaker(NewCircuitBreaker(cfg.ArgoCD.BreakerThreshold, cfg.ArgoCD.BreakerCooldown)).
+			WithCallTimeout(cfg.ArgoCD.CallTimeout)
 	}
 
-	api := &MeeseeksAPI{argoCDClient: client}
+	api := &MeeseeksAPI{
+		argoCDClient: client,
+		catalog:      catalog,
+		config:       &cfg,
+		policy:       NewPolicy(cfg.Auth.AdminGroups),
+		quotas:       NewQuotas(cfg.Quotas, catalog),
+		events:       NewEnvironmentEvents(client, cfg.Events.Interval),
+		now:          time.Now,
+	}
+
+	auth, err := NewAuth(context.Background(), cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %v", err)
+	}
+	if !auth.Enabled() {
+		log.Println("⚠️  Authentication is disabled; anyone who can reach meeseeks can manage environments")
+	}
 
 	mux := http.NewServeMux()
+	auth.Register(mux)
 
 	// Frontend routes
 	mux.HandleFunc("/", api.serveHome)
 
+	mux.HandleFunc("/healthz", api.healthz)
+
+	mux.HandleFunc("/quotas/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		api.myQuotas(w, r)
+	})
+
 	// API routes - existing JSON endpoints
 	mux.HandleFunc("/environments", func(w http.ResponseWriter, r *http.Request) {
 		// Check if request is from HTMX
@@ -478,41 +1160,19 @@ func main() {
 		}
 	})
 
-	mux.HandleFunc("/environments/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodDelete {
-			envID := r.URL.Path[len("/environments/"):]
-			if envID == "" {
-				http.Error(w, "Environment ID is required", http.StatusBadRequest)
-				return
-			}
+	mux.HandleFunc("/environments/", api.serveEnvironment)
 
-			if err := api.argoCDClient.DeleteApplication(envID); err != nil {
-				if r.Header.Get("HX-Request") == "true" {
-					w.Header().Set("Content-Type", "text/html")
-					fmt.Fprintf(w, `<div class="error">Failed to delete environment: %v</div>`, err)
-				} else {
-					http.Error(w, fmt.Sprintf("Failed to delete environment: %v", err), http.StatusInternalServerError)
-				}
-				return
-			}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			if r.Header.Get("HX-Request") == "true" {
-				// Return empty content to remove the element from DOM
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(http.StatusNoContent)
-			}
-		} else {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		}
+	log.Printf("Meeseeks API server starting on port %s", cfg.Port)
+	log.Printf("Frontend available at: http://localhost:%s", cfg.Port)
+	err = serve(ctx, ":"+cfg.Port, auth.Middleware(mux), cfg.ServerTimeouts(), func(ctx context.Context, workers *Workers) {
+		workers.Go(ctx, "reaper", NewReaper(client, cfg.Reaper.Interval).Run)
+		workers.Go(ctx, "events", api.events.Run)
 	})
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "22282"
+	if err != nil {
+		log.Fatalf("Server stopped: %v", err)
 	}
-
-	log.Printf("Meeseeks API server starting on port %s", port)
-	log.Printf("Frontend available at: http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, mux))
+	log.Println("Server stopped")
 }
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock tests advance by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// newTestAPI returns an API backed by the mock ArgoCD with the default
+// config and catalog.
+func newTestAPI(t *testing.T) (*MeeseeksAPI, *MockArgoCDClient) {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	catalog := DefaultCatalog()
+	mock := NewMockArgoCDClient(catalog).WithApplicationSettings(cfg.ApplicationSettings())
+
+	return &MeeseeksAPI{
+		argoCDClient: mock,
+		catalog:      catalog,
+		config:       &cfg,
+		policy:       NewPolicy(cfg.Auth.AdminGroups),
+		quotas:       NewQuotas(cfg.Quotas, catalog),
+		events:       NewEnvironmentEvents(mock, cfg.Events.Interval),
+		now:          time.Now,
+	}, mock
+}
+
+// call invokes the API handler for method and path as the server's mux
+// would route a JSON request.
+func call(t *testing.T, handler http.HandlerFunc, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func decodeDetail(t *testing.T, rec *httptest.ResponseRecorder) EnvironmentDetail {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET returned %d: %s", rec.Code, rec.Body)
+	}
+	var detail EnvironmentDetail
+	if err := json.NewDecoder(rec.Body).Decode(&detail); err != nil {
+		t.Fatalf("failed to decode environment: %v", err)
+	}
+	return detail
+}
+
+func TestEnvironmentLifecycle(t *testing.T) {
+	api, mock := newTestAPI(t)
+	clock := newFakeClock()
+	mock.WithClock(clock.Now)
+	api.now = clock.Now
+
+	rec := call(t, api.createEnvironment, http.MethodPost, "/environments",
+		`{"name": "lifecycle", "branch": "main", "dependencies": ["postgresql"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+	var created EnvironmentResponse
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "lifecycle" || created.Status != "creating" || len(created.Secrets) != 1 {
+		t.Errorf("create response = %+v, want lifecycle, creating, one secret", created)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		sync    string
+		health  string
+	}{
+		{0, "OutOfSync", "Missing"},
+		{mock.ProgressingAfter, "Synced", "Progressing"},
+		{mock.HealthyAfter - mock.ProgressingAfter, "Synced", "Healthy"},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		detail := decodeDetail(t, call(t, api.getEnvironment, http.MethodGet, "/environments/lifecycle", ""))
+		if detail.SyncStatus != step.sync || detail.HealthStatus != step.health {
+			t.Errorf("after %s: status = %s/%s, want %s/%s",
+				step.advance, detail.SyncStatus, detail.HealthStatus, step.sync, step.health)
+		}
+	}
+
+	rec = call(t, api.waitEnvironment, http.MethodGet, "/environments/lifecycle/wait?timeout=1s", "")
+	if rec.Code != http.StatusOK {
+		t.Errorf("wait on a healthy environment returned %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = call(t, api.deleteEnvironment, http.MethodDelete, "/environments/lifecycle", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", rec.Code, rec.Body)
+	}
+	rec = call(t, api.getEnvironment, http.MethodGet, "/environments/lifecycle", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET after delete returned %d, want 404", rec.Code)
+	}
+}
+
+func TestCreateEnvironmentArgoCDErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{
+			name:       "already exists",
+			err:        &ArgoCDError{StatusCode: http.StatusConflict, Code: grpcAlreadyExists, Message: "already exists"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "argocd unavailable",
+			err:        &ArgoCDError{StatusCode: http.StatusServiceUnavailable, Code: grpcUnavailable, Message: "unavailable"},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "token rejected",
+			err:        &ArgoCDError{StatusCode: http.StatusUnauthorized, Code: grpcUnauthenticated, Message: "invalid session"},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "unexpected error",
+			err:        errors.New("connection reset"),
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, mock := newTestAPI(t)
+			mock.FailNext("CreateApplication", tt.err)
+
+			rec := call(t, api.createEnvironment, http.MethodPost, "/environments", `{"name": "failing", "branch": "main"}`)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("create returned %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), "Failed to create environment") {
+				t.Errorf("body = %q, want the error prefix", rec.Body)
+			}
+			if _, ok := mock.Application("failing"); ok {
+				t.Error("failed create stored the environment")
+			}
+
+			// The failure is consumed; the retry succeeds.
+			rec = call(t, api.createEnvironment, http.MethodPost, "/environments", `{"name": "failing", "branch": "main"}`)
+			if rec.Code != http.StatusOK {
+				t.Errorf("retried create returned %d: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDegradedEnvironment(t *testing.T) {
+	api, mock := newTestAPI(t)
+	clock := newFakeClock()
+	mock.WithClock(clock.Now)
+	api.now = clock.Now
+
+	rec := call(t, api.createEnvironment, http.MethodPost, "/environments", `{"name": "crashing", "branch": "main"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+	clock.Advance(mock.HealthyAfter)
+	if err := mock.SetHealth("crashing", "Degraded", "back-off restarting failed container"); err != nil {
+		t.Fatal(err)
+	}
+
+	detail := decodeDetail(t, call(t, api.getEnvironment, http.MethodGet, "/environments/crashing", ""))
+	if detail.HealthStatus != "Degraded" || detail.HealthMessage != "back-off restarting failed container" {
+		t.Errorf("health = %s (%q), want Degraded with the pinned message", detail.HealthStatus, detail.HealthMessage)
+	}
+
+	rec = call(t, api.waitEnvironment, http.MethodGet, "/environments/crashing/wait?timeout=1s", "")
+	if rec.Code != http.StatusFailedDependency {
+		t.Fatalf("wait returned %d, want %d: %s", rec.Code, http.StatusFailedDependency, rec.Body)
+	}
+	var response EnvironmentResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != waitDegraded || response.Wait == nil || len(response.Wait.FailingResources) == 0 {
+		t.Errorf("wait response = %+v, want degraded with the failing Deployment", response)
+	}
+}
+
+func TestGetEnvironmentHTMXEscapesRequest(t *testing.T) {
+	api, _ := newTestAPI(t)
+
+	const branch = `<script>alert(1)</script>`
+	body := `{"name": "xss", "branch": "` + branch + `"}`
+	rec := httptest.NewRecorder()
+	api.createEnvironment(rec, httptest.NewRequest(http.MethodPost, "/environments", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/environments/xss", nil)
+	req.Header.Set("HX-Request", "true")
+	rec = httptest.NewRecorder()
+	api.getEnvironmentHTMX(rec, req)
+
+	got := rec.Body.String()
+	if strings.Contains(got, branch) {
+		t.Fatalf("branch was rendered unescaped:\n%s", got)
+	}
+	if !strings.Contains(got, "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Errorf("escaped branch missing from fragment:\n%s", got)
+	}
+}
+
+func TestHTMXErrorsAreEscaped(t *testing.T) {
+	api, mock := newTestAPI(t)
+	mock.FailNext("ListApplications", errors.New(`<img src=x onerror=alert(1)>`))
+
+	req := httptest.NewRequest(http.MethodGet, "/environments", nil)
+	req.Header.Set("HX-Request", "true")
+	rec := httptest.NewRecorder()
+	api.listEnvironmentsHTMX(rec, req)
+
+	got := rec.Body.String()
+	if strings.Contains(got, "<img") {
+		t.Fatalf("error was rendered unescaped:\n%s", got)
+	}
+	if !strings.Contains(got, "&lt;img src=x onerror=alert(1)&gt;") {
+		t.Errorf("escaped error missing from fragment:\n%s", got)
+	}
+}
+
+func TestEnvironmentNamedAfterAnAction(t *testing.T) {
+	api, mock := newTestAPI(t)
+	createExpiring(t, mock, "extend", nil)
+
+	detail := decodeDetail(t, call(t, api.serveEnvironment, http.MethodGet, "/environments/extend", ""))
+	if detail.Name != "extend" {
+		t.Errorf("GET returned %q, want extend", detail.Name)
+	}
+
+	rec := call(t, api.serveEnvironment, http.MethodPost, "/environments/extend/extend", `{"ttl": "1h"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("extend returned %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = call(t, api.serveEnvironment, http.MethodGet, "/environments/extend/unknown", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown action returned %d, want 404", rec.Code)
+	}
+
+	rec = call(t, api.serveEnvironment, http.MethodDelete, "/environments/extend", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", rec.Code, rec.Body)
+	}
+	if _, ok := mock.Application("extend"); ok {
+		t.Error("DELETE left the environment in place")
+	}
+}
+
+func TestCreateRejectsReservedNames(t *testing.T) {
+	for _, name := range reservedNames {
+		api, _ := newTestAPI(t)
+		rec := call(t, api.createEnvironment, http.MethodPost, "/environments", `{"name": "`+name+`", "branch": "main"}`)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("create %s returned %d, want 422: %s", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestInvalidEnvironmentIDNeverReachesArgoCD(t *testing.T) {
+	api, mock := newTestAPI(t)
+	createExpiring(t, mock, "foo", nil)
+	mock.FailNext("DeleteApplication", errors.New("DeleteApplication was called"))
+
+	for _, path := range []string{"/environments/foo%3Fcascade=false", "/environments/foo%23bar", "/environments/Foo"} {
+		rec := call(t, api.serveEnvironment, http.MethodDelete, path, "")
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("DELETE %s returned %d, want 400: %s", path, rec.Code, rec.Body)
+		}
+	}
+	rec := call(t, api.serveEnvironment, http.MethodDelete, "/environments/foo%2Fbar", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE with an embedded slash returned %d, want 404: %s", rec.Code, rec.Body)
+	}
+
+	if _, ok := mock.Application("foo"); !ok {
+		t.Error("foo was deleted")
+	}
+}
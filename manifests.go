@@ -0,0 +1,286 @@
+package main
+
+// Minimal Kubernetes object types for the companion resources meeseeks
+// renders next to an app. Only the fields meeseeks sets are modelled.
+
+type k8sObjectMeta struct {
+	Name        string            `json:"name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type k8sDeployment struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   k8sObjectMeta     `json:"metadata"`
+	Spec       k8sDeploymentSpec `json:"spec"`
+}
+
+type k8sDeploymentSpec struct {
+	Replicas int                    `json:"replicas"`
+	Selector k8sLabelSelector       `json:"selector"`
+	Strategy *k8sDeploymentStrategy `json:"strategy,omitempty"`
+	Template k8sPodTemplate         `json:"template"`
+}
+
+type k8sDeploymentStrategy struct {
+	Type string `json:"type"`
+}
+
+type k8sLabelSelector struct {
+	MatchLabels      map[string]string             `json:"matchLabels,omitempty"`
+	MatchExpressions []k8sLabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+type k8sLabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+type k8sPodTemplate struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     k8sPodSpec    `json:"spec"`
+}
+
+type k8sPodSpec struct {
+	ServiceAccountName string                 `json:"serviceAccountName,omitempty"`
+	RestartPolicy      string                 `json:"restartPolicy,omitempty"`
+	SecurityContext    *k8sPodSecurityContext `json:"securityContext,omitempty"`
+	Containers         []k8sContainer         `json:"containers"`
+	Volumes            []k8sVolume            `json:"volumes,omitempty"`
+}
+
+type k8sPodSecurityContext struct {
+	RunAsNonRoot   bool               `json:"runAsNonRoot,omitempty"`
+	RunAsUser      int64              `json:"runAsUser,omitempty"`
+	SeccompProfile *k8sSeccompProfile `json:"seccompProfile,omitempty"`
+}
+
+type k8sSeccompProfile struct {
+	Type string `json:"type"`
+}
+
+type k8sContainer struct {
+	Name            string                `json:"name"`
+	Image           string                `json:"image"`
+	Command         []string              `json:"command,omitempty"`
+	Ports           []k8sContainerPort    `json:"ports,omitempty"`
+	Env             []envVar              `json:"env,omitempty"`
+	Resources       *resourceRequirements `json:"resources,omitempty"`
+	VolumeMounts    []k8sVolumeMount      `json:"volumeMounts,omitempty"`
+	SecurityContext *k8sSecurityContext   `json:"securityContext,omitempty"`
+}
+
+type k8sSecurityContext struct {
+	AllowPrivilegeEscalation *bool            `json:"allowPrivilegeEscalation,omitempty"`
+	Capabilities             *k8sCapabilities `json:"capabilities,omitempty"`
+}
+
+type k8sCapabilities struct {
+	Drop []string `json:"drop,omitempty"`
+}
+
+type k8sContainerPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int    `json:"containerPort"`
+}
+
+type k8sVolume struct {
+	Name                  string              `json:"name"`
+	PersistentVolumeClaim *k8sPVCVolumeSource `json:"persistentVolumeClaim,omitempty"`
+}
+
+type k8sPVCVolumeSource struct {
+	ClaimName string `json:"claimName"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+}
+
+type k8sService struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   k8sObjectMeta  `json:"metadata"`
+	Spec       k8sServiceSpec `json:"spec"`
+}
+
+type k8sServiceSpec struct {
+	Selector map[string]string `json:"selector"`
+	Ports    []k8sServicePort  `json:"ports"`
+}
+
+type k8sServicePort struct {
+	Name       string `json:"name,omitempty"`
+	Port       int    `json:"port"`
+	TargetPort int    `json:"targetPort"`
+}
+
+type k8sPersistentVolumeClaim struct {
+	APIVersion string                       `json:"apiVersion"`
+	Kind       string                       `json:"kind"`
+	Metadata   k8sObjectMeta                `json:"metadata"`
+	Spec       k8sPersistentVolumeClaimSpec `json:"spec"`
+}
+
+type k8sPersistentVolumeClaimSpec struct {
+	AccessModes []string             `json:"accessModes"`
+	Resources   resourceRequirements `json:"resources"`
+}
+
+type k8sJob struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+	Spec       k8sJobSpec    `json:"spec"`
+}
+
+type k8sJobSpec struct {
+	BackoffLimit int            `json:"backoffLimit"`
+	Template     k8sPodTemplate `json:"template"`
+}
+
+type k8sNamespace struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+}
+
+type k8sServiceAccount struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+}
+
+type k8sRole struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   k8sObjectMeta   `json:"metadata"`
+	Rules      []k8sPolicyRule `json:"rules"`
+}
+
+type k8sPolicyRule struct {
+	APIGroups []string `json:"apiGroups"`
+	Resources []string `json:"resources"`
+	Verbs     []string `json:"verbs"`
+}
+
+type k8sRoleBinding struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   k8sObjectMeta `json:"metadata"`
+	RoleRef    k8sRoleRef    `json:"roleRef"`
+	Subjects   []k8sSubject  `json:"subjects"`
+}
+
+type k8sRoleRef struct {
+	APIGroup string `json:"apiGroup"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+}
+
+type k8sSubject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+type k8sResourceQuota struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   k8sObjectMeta        `json:"metadata"`
+	Spec       k8sResourceQuotaSpec `json:"spec"`
+}
+
+type k8sResourceQuotaSpec struct {
+	Hard map[string]string `json:"hard"`
+}
+
+type k8sLimitRange struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   k8sObjectMeta     `json:"metadata"`
+	Spec       k8sLimitRangeSpec `json:"spec"`
+}
+
+type k8sLimitRangeSpec struct {
+	Limits []k8sLimitRangeItem `json:"limits"`
+}
+
+type k8sLimitRangeItem struct {
+	Type           string            `json:"type"`
+	Default        map[string]string `json:"default,omitempty"`
+	DefaultRequest map[string]string `json:"defaultRequest,omitempty"`
+	Max            map[string]string `json:"max,omitempty"`
+}
+
+type k8sNetworkPolicy struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   k8sObjectMeta        `json:"metadata"`
+	Spec       k8sNetworkPolicySpec `json:"spec"`
+}
+
+// k8sNetworkPolicySpec always encodes Ingress, so a policy without rules
+// denies all ingress to the pods it selects.
+type k8sNetworkPolicySpec struct {
+	PodSelector k8sLabelSelector              `json:"podSelector"`
+	PolicyTypes []string                      `json:"policyTypes"`
+	Ingress     []k8sNetworkPolicyIngressRule `json:"ingress"`
+}
+
+type k8sNetworkPolicyIngressRule struct {
+	From []k8sNetworkPolicyPeer `json:"from"`
+}
+
+type k8sNetworkPolicyPeer struct {
+	PodSelector       *k8sLabelSelector `json:"podSelector,omitempty"`
+	NamespaceSelector *k8sLabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+type k8sIngress struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   k8sObjectMeta  `json:"metadata"`
+	Spec       k8sIngressSpec `json:"spec"`
+}
+
+type k8sIngressSpec struct {
+	IngressClassName string           `json:"ingressClassName,omitempty"`
+	TLS              []k8sIngressTLS  `json:"tls,omitempty"`
+	Rules            []k8sIngressRule `json:"rules"`
+}
+
+type k8sIngressTLS struct {
+	Hosts      []string `json:"hosts"`
+	SecretName string   `json:"secretName"`
+}
+
+type k8sIngressRule struct {
+	Host string                  `json:"host"`
+	HTTP k8sHTTPIngressRuleValue `json:"http"`
+}
+
+type k8sHTTPIngressRuleValue struct {
+	Paths []k8sHTTPIngressPath `json:"paths"`
+}
+
+type k8sHTTPIngressPath struct {
+	Path     string            `json:"path"`
+	PathType string            `json:"pathType"`
+	Backend  k8sIngressBackend `json:"backend"`
+}
+
+type k8sIngressBackend struct {
+	Service k8sIngressServiceBackend `json:"service"`
+}
+
+type k8sIngressServiceBackend struct {
+	Name string                `json:"name"`
+	Port k8sServiceBackendPort `json:"port"`
+}
+
+type k8sServiceBackendPort struct {
+	Number int `json:"number"`
+}
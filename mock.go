@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MockArgoCDClient is an in-memory ArgoCD used by dev mode and as a test
+// double. It stores the Applications meeseeks would send to ArgoCD and
+// reports a status that moves from Missing to Progressing to Healthy as time
+// passes since the last create or update.
+type MockArgoCDClient struct {
+	// ProgressingAfter and HealthyAfter control how long after a create or
+	// update an environment reports Progressing and then Healthy.
+	ProgressingAfter time.Duration
+	HealthyAfter     time.Duration
+
+	builder *ArgoCDClient
+	now     func() time.Time
+
+	mu       sync.Mutex
+	apps     map[string]*mockApplication
+	failures map[string][]error
+	version  int
+}
+
+type mockApplication struct {
+	app        ArgoCDApplication
+	deployedAt time.Time
+	health     *ArgoCDHealthStatus
+}
+
+func NewMockArgoCDClient(catalog *Catalog) *MockArgoCDClient {
+	return &MockArgoCDClient{
+		ProgressingAfter: 2 * time.Second,
+		HealthyAfter:     10 * time.Second,
+		builder:          &ArgoCDClient{catalog: catalog, settings: DefaultApplicationSettings()},
+		now:              time.Now,
+		apps:             make(map[string]*mockApplication),
+		failures:         make(map[string][]error),
+	}
+}
+
+// WithApplicationSettings replaces the project, namespaces and domain used
+// for stored Applications.
+func (m *MockArgoCDClient) WithApplicationSettings(settings ApplicationSettings) *MockArgoCDClient {
+	m.builder.settings = settings
+	return m
+}
+
+// WithClock replaces the clock the simulated status transitions are measured
+// against, so tests can move an environment to Healthy without sleeping.
+func (m *MockArgoCDClient) WithClock(now func() time.Time) *MockArgoCDClient {
+	m.now = now
+	return m
+}
+
+// FailNext makes the next call to method (e.g. "CreateApplication") return
+// err. Calls queue up, so FailNext twice fails the next two calls.
+func (m *MockArgoCDClient) FailNext(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[method] = append(m.failures[method], err)
+}
+
+// SetHealth pins an environment's health, e.g. to Degraded, overriding the
+// simulated transitions until the next update.
+func (m *MockArgoCDClient) SetHealth(name, status, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.apps[name]
+	if !ok {
+		return mockNotFound(name)
+	}
+	stored.health = &ArgoCDHealthStatus{Status: status, Message: message}
+	return nil
+}
+
+// Application returns a copy of the stored Application, including its
+// simulated status.
+func (m *MockArgoCDClient) Application(name string) (ArgoCDApplication, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.apps[name]
+	if !ok {
+		return ArgoCDApplication{}, false
+	}
+	return m.withStatus(stored), true
+}
+
+func (m *MockArgoCDClient) CreateApplication(ctx context.Context, req EnvironmentRequest) (string, error) {
+	log.Printf("Mock: Creating environment %s", req.Name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "CreateApplication"); err != nil {
+		return "", err
+	}
+
+	if _, exists := m.apps[req.Name]; exists {
+		return "", &ArgoCDError{
+			StatusCode: http.StatusConflict,
+			Code:       grpcAlreadyExists,
+			Message:    fmt.Sprintf("applications.argoproj.io %q already exists", req.Name),
+		}
+	}
+
+	app, err := m.builder.buildApplication(req)
+	if err != nil {
+		return "", err
+	}
+
+	if err := m.store(app); err != nil {
+		return "", err
+	}
+
+	return req.Name, nil
+}
+
+func (m *MockArgoCDClient) ListApplications(ctx context.Context) (EnvironmentList, error) {
+	log.Printf("Mock: Listing applications")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "ListApplications"); err != nil {
+		return EnvironmentList{}, err
+	}
+
+	names := make([]string, 0, len(m.apps))
+	for name := range m.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var environments []EnvironmentItem
+	for _, name := range names {
+		environments = append(environments, m.builder.environmentItem(m.withStatus(m.apps[name])))
+	}
+
+	return EnvironmentList{Items: environments}, nil
+}
+
+// ListEnvironmentDetails doesn't log like the other calls, since the event
+// stream polls it every few seconds.
+func (m *MockArgoCDClient) ListEnvironmentDetails(ctx context.Context) ([]EnvironmentDetail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "ListEnvironmentDetails"); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(m.apps))
+	for name := range m.apps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	details := make([]EnvironmentDetail, 0, len(names))
+	for _, name := range names {
+		details = append(details, m.builder.environmentDetail(m.withStatus(m.apps[name])))
+	}
+
+	return details, nil
+}
+
+func (m *MockArgoCDClient) GetApplication(ctx context.Context, name string) (EnvironmentDetail, error) {
+	log.Printf("Mock: Getting application %s", name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "GetApplication"); err != nil {
+		return EnvironmentDetail{}, err
+	}
+
+	stored, ok := m.apps[name]
+	if !ok {
+		return EnvironmentDetail{}, mockNotFound(name)
+	}
+
+	return m.builder.environmentDetail(m.withStatus(stored)), nil
+}
+
+func (m *MockArgoCDClient) UpdateApplication(ctx context.Context, req EnvironmentRequest, resourceVersion string) error {
+	log.Printf("Mock: Updating application %s", req.Name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "UpdateApplication"); err != nil {
+		return err
+	}
+
+	current, ok := m.apps[req.Name]
+	if !ok {
+		return mockNotFound(req.Name)
+	}
+
+	if resourceVersion != "" && current.app.Metadata.ResourceVersion != resourceVersion {
+		return fmt.Errorf("%w: application %s has resourceVersion %s, expected %s",
+			ErrConflict, req.Name, current.app.Metadata.ResourceVersion, resourceVersion)
+	}
+
+	app, err := m.builder.buildApplication(req)
+	if err != nil {
+		return err
+	}
+
+	return m.store(app)
+}
+
+func (m *MockArgoCDClient) DeleteApplication(ctx context.Context, name string) error {
+	log.Printf("Mock: Deleting application %s", name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.callError(ctx, "DeleteApplication"); err != nil {
+		return err
+	}
+
+	if _, ok := m.apps[name]; !ok {
+		return mockNotFound(name)
+	}
+
+	delete(m.apps, name)
+	return nil
+}
+
+// store saves app with a new resourceVersion and restarts its simulated
+// rollout. The Application is round-tripped through JSON so it looks the same
+// as one read back from ArgoCD. Callers must hold m.mu.
+func (m *MockArgoCDClient) store(app ArgoCDApplication) error {
+	m.version++
+	app.Metadata.ResourceVersion = strconv.Itoa(m.version)
+
+	data, err := json.Marshal(app)
+	if err != nil {
+		return fmt.Errorf("failed to marshal application: %w", err)
+	}
+
+	var stored ArgoCDApplication
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("failed to decode application: %w", err)
+	}
+
+	m.apps[app.Metadata.Name] = &mockApplication{
+		app:        stored,
+		deployedAt: m.now(),
+	}
+	return nil
+}
+
+// callError returns the context's error if it is already done, otherwise the
+// next queued failure for method. Callers must hold m.mu.
+func (m *MockArgoCDClient) callError(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	queue := m.failures[method]
+	if len(queue) == 0 {
+		return nil
+	}
+	m.failures[method] = queue[1:]
+	return queue[0]
+}
+
+// withStatus returns a copy of the stored Application with a status derived
+// from the time since it was last deployed. Callers must hold m.mu.
+func (m *MockArgoCDClient) withStatus(stored *mockApplication) ArgoCDApplication {
+	app := stored.app
+	elapsed := m.now().Sub(stored.deployedAt)
+
+	sync := ArgoCDSyncStatus{Status: "Synced", Revision: mockRevision(app)}
+	health := ArgoCDHealthStatus{Status: "Healthy"}
+	operation := &ArgoCDOperationState{
+		Phase:     "Succeeded",
+		Message:   "successfully synced (all tasks run)",
+		StartedAt: stored.deployedAt.UTC().Format(time.RFC3339),
+	}
+
+	switch {
+	case elapsed < m.ProgressingAfter:
+		sync.Status = "OutOfSync"
+		health.Status = "Missing"
+		operation.Phase = "Running"
+		operation.Message = "waiting for sync to start"
+	case elapsed < m.HealthyAfter:
+		health.Status = "Progressing"
+		operation.Phase = "Running"
+		operation.Message = "waiting for healthy state of apps/Deployment"
+	default:
+		operation.FinishedAt = stored.deployedAt.Add(m.HealthyAfter).UTC().Format(time.RFC3339)
+	}
+
+	if stored.health != nil {
+		health = *stored.health
+	}
+
+	var resources []ArgoCDResourceStatus
+	for _, ref := range m.managedResources(app) {
+		ref.Status = sync.Status
+		if ref.Kind == "Deployment" {
+			ref.Health = &ArgoCDHealthStatus{Status: health.Status, Message: health.Message}
+		} else if health.Status != "Missing" {
+			ref.Health = &ArgoCDHealthStatus{Status: "Healthy"}
+		}
+		resources = append(resources, ref)
+	}
+
+	var summary ArgoCDApplicationSummary
+	if health.Status != "Missing" {
+		summary.ExternalURLs = companionIngressURLs(app)
+	}
+
+	app.Status = &ArgoCDApplicationStatus{
+		Summary:        summary,
+		Sync:           sync,
+		Health:         health,
+		OperationState: operation,
+		Resources:      resources,
+		ReconciledAt:   m.now().UTC().Format(time.RFC3339),
+	}
+	return app
+}
+
+// managedResources lists the resources ArgoCD would track for app: the
+// catalog app's Deployment plus every companion resource that isn't a hook.
+func (m *MockArgoCDClient) managedResources(app ArgoCDApplication) []ArgoCDResourceStatus {
+	namespace := app.Spec.Destination.Namespace
+
+	var resources []ArgoCDResourceStatus
+	if catalogApp, err := m.builder.catalog.Lookup(app.Metadata.Labels["app"]); err == nil {
+		resources = append(resources, ArgoCDResourceStatus{
+			Group:     "apps",
+			Version:   "v1",
+			Kind:      "Deployment",
+			Namespace: namespace,
+			Name:      catalogApp.Deployment,
+		})
+	}
+
+	for _, source := range app.Spec.Sources {
+		if source.Helm == nil {
+			continue
+		}
+		companions, _ := source.Helm.ValuesObject["resources"].([]any)
+		for _, raw := range companions {
+			resource, _ := raw.(map[string]any)
+			metadata, _ := resource["metadata"].(map[string]any)
+			// ArgoCD doesn't list hooks among an app's resources.
+			if annotations, _ := metadata["annotations"].(map[string]any); annotations["argocd.argoproj.io/hook"] != nil {
+				continue
+			}
+			kind, _ := resource["kind"].(string)
+			name, _ := metadata["name"].(string)
+			resources = append(resources, ArgoCDResourceStatus{
+				Kind:      kind,
+				Namespace: namespace,
+				Name:      name,
+			})
+		}
+	}
+
+	return resources
+}
+
+func mockNotFound(name string) error {
+	return &ArgoCDError{
+		StatusCode: http.StatusNotFound,
+		Code:       grpcNotFound,
+		Message:    fmt.Sprintf("applications.argoproj.io %q not found", name),
+	}
+}
+
+// mockRevision fakes a commit SHA that changes with the app's target revision.
+func mockRevision(app ArgoCDApplication) string {
+	revision := requestFromApplication(app).Branch
+	return fmt.Sprintf("%040x", []byte(revision))[:40]
+}
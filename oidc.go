@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OIDCProvider implements the authorization code flow with PKCE against an
+// OpenID Connect identity provider.
+//
+// ID tokens are verified against the provider's signing keys before their
+// claims are validated. The issuer, token endpoint and keys must be served
+// over HTTPS.
+type OIDCProvider struct {
+	config        OIDCConfig
+	issuer        string
+	authEndpoint  string
+	tokenEndpoint string
+	keys          *JWKS
+	client        *http.Client
+	now           func() time.Time
+}
+
+// NewOIDCProvider reads the provider's discovery document.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider := &OIDCProvider{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+	if err := provider.discover(ctx); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) error {
+	if err := requireHTTPS("issuer", p.config.IssuerURL); err != nil {
+		return err
+	}
+
+	discoveryURL := strings.TrimSuffix(p.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OIDC discovery returned status %d", resp.StatusCode)
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return fmt.Errorf("failed to decode OIDC discovery document: %w", err)
+	}
+
+	if discovery.Issuer != p.config.IssuerURL {
+		return fmt.Errorf("OIDC issuer mismatch: configured %s, provider reports %s", p.config.IssuerURL, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return fmt.Errorf("OIDC discovery document is missing endpoints")
+	}
+	if err := requireHTTPS("token endpoint", discovery.TokenEndpoint); err != nil {
+		return err
+	}
+	if err := requireHTTPS("jwks_uri", discovery.JWKSURI); err != nil {
+		return err
+	}
+
+	p.issuer = discovery.Issuer
+	p.authEndpoint = discovery.AuthorizationEndpoint
+	p.tokenEndpoint = discovery.TokenEndpoint
+	p.keys = NewJWKS(discovery.JWKSURI, p.client)
+	return nil
+}
+
+func requireHTTPS(name, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("OIDC %s must be an https URL, got %q", name, rawURL)
+	}
+	return nil
+}
+
+// AuthCodeURL returns the provider URL the user is redirected to for login.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) string {
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the identity from the
+// validated ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	if code == "" {
+		return nil, fmt.Errorf("missing authorization code")
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, body)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+
+	claims, err := p.verifyIDToken(ctx, token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.identityFromClaims(claims, nonce)
+}
+
+// verifyIDToken checks the ID token's signature and returns its claims.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, idToken string) (map[string]any, error) {
+	payload, err := p.keys.Verify(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) identityFromClaims(claims map[string]any, nonce string) (*Identity, error) {
+	if iss, _ := claims["iss"].(string); iss != p.issuer {
+		return nil, fmt.Errorf("ID token issuer %q does not match %q", iss, p.issuer)
+	}
+
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, p.config.ClientID) {
+		return nil, fmt.Errorf("ID token was not issued for client %s", p.config.ClientID)
+	}
+
+	exp, _ := claims["exp"].(float64)
+	if p.now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+
+	name, _ := claims[p.config.UsernameClaim].(string)
+	// An unverified email address could belong to anyone, so it can't name
+	// the owner of environments.
+	if name != "" && p.config.UsernameClaim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return nil, fmt.Errorf("ID token email %s is not verified", name)
+		}
+	}
+	if name == "" {
+		name, _ = claims["sub"].(string)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("ID token has no %s or sub claim", p.config.UsernameClaim)
+	}
+
+	identity := &Identity{Name: name, Method: "oidc"}
+	if groups, ok := claims[p.config.GroupsClaim].([]any); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIdentityProvider serves discovery, signing keys and a token endpoint
+// that returns idToken. With a challenge set, the token endpoint also
+// checks the PKCE code verifier against it.
+type fakeIdentityProvider struct {
+	server    *httptest.Server
+	rsaKey    *rsa.PrivateKey
+	ecKey     *ecdsa.PrivateKey
+	idToken   string
+	challenge string
+}
+
+func newFakeIdentityProvider(t *testing.T) *fakeIdentityProvider {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdentityProvider{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if idp.challenge != "" {
+			verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+			if base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.idToken})
+	})
+	idp.server = httptest.NewTLSServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// provider returns an OIDCProvider that has read idp's discovery document.
+func (idp *fakeIdentityProvider) provider(t *testing.T) *OIDCProvider {
+	t.Helper()
+	provider := &OIDCProvider{
+		config: OIDCConfig{
+			IssuerURL:     idp.server.URL,
+			ClientID:      "meeseeks",
+			RedirectURL:   "https://meeseeks.example.com/auth/callback",
+			UsernameClaim: "email",
+			GroupsClaim:   "groups",
+		},
+		client: idp.server.Client(),
+		now:    func() time.Time { return testNow },
+	}
+	if err := provider.discover(context.Background()); err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	return provider
+}
+
+func (idp *fakeIdentityProvider) claims() map[string]any {
+	return map[string]any{
+		"iss":            idp.server.URL,
+		"aud":            "meeseeks",
+		"sub":            "1234",
+		"exp":            testNow.Add(time.Hour).Unix(),
+		"nonce":          "nonce",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"groups":         []string{"platform"},
+	}
+}
+
+// sign returns a compact JWS of claims signed with alg under kid.
+func (idp *fakeIdentityProvider) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, idp.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCExchangeVerifiesIDToken(t *testing.T) {
+	idp := newFakeIdentityProvider(t)
+	valid := idp.claims()
+	unverified := idp.claims()
+	unverified["email_verified"] = false
+	expired := idp.claims()
+	expired["exp"] = testNow.Add(-time.Minute).Unix()
+	otherAudience := idp.claims()
+	otherAudience["aud"] = "someone-else"
+
+	forged := idp.sign(t, "RS256", "rsa", valid)
+	parts := strings.Split(forged, ".")
+	tampered := idp.claims()
+	tampered["email"] = "admin@example.com"
+	tamperedPayload, _ := json.Marshal(tampered)
+	forged = parts[0] + "." + base64.RawURLEncoding.EncodeToString(tamperedPayload) + "." + parts[2]
+
+	unsignedHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	validPayload, _ := json.Marshal(valid)
+	unsigned := unsignedHeader + "." + base64.RawURLEncoding.EncodeToString(validPayload) + "."
+
+	tests := []struct {
+		name    string
+		idToken string
+		wantErr string
+	}{
+		{name: "RS256", idToken: idp.sign(t, "RS256", "rsa", valid)},
+		{name: "ES256", idToken: idp.sign(t, "ES256", "ec", valid)},
+		{name: "tampered claims", idToken: forged, wantErr: "signature is invalid"},
+		{name: "unsigned", idToken: unsigned, wantErr: "unsupported algorithm"},
+		{name: "wrong key for algorithm", idToken: idp.sign(t, "ES256", "rsa", valid), wantErr: "signature is invalid"},
+		{name: "unknown key", idToken: idp.sign(t, "RS256", "rotated", valid), wantErr: "unknown key"},
+		{name: "unverified email", idToken: idp.sign(t, "RS256", "rsa", unverified), wantErr: "not verified"},
+		{name: "expired", idToken: idp.sign(t, "RS256", "rsa", expired), wantErr: "expired"},
+		{name: "other audience", idToken: idp.sign(t, "RS256", "rsa", otherAudience), wantErr: "not issued for client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := idp.provider(t)
+			idp.idToken = tt.idToken
+
+			identity, err := provider.Exchange(context.Background(), "code", "verifier", "nonce")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if identity.Name != "jane@example.com" || len(identity.Groups) != 1 || identity.Groups[0] != "platform" {
+				t.Errorf("identity = %+v, want jane@example.com in platform", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCRequiresHTTPS(t *testing.T) {
+	provider := &OIDCProvider{
+		config: OIDCConfig{IssuerURL: "http://idp.example.com"},
+		client: http.DefaultClient,
+		now:    time.Now,
+	}
+	if err := provider.discover(context.Background()); err == nil || !strings.Contains(err.Error(), "https") {
+		t.Errorf("discover error = %v, want an https error", err)
+	}
+}
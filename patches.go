@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// The types below mirror the subset of the apps/v1 Deployment schema that
+// meeseeks patches. Patches are rendered as strategic-merge documents by
+// marshalling these types to JSON, which Kustomize accepts as YAML. Building
+// them from structs rather than text keeps user input such as env var values
+// correctly escaped, and lets containers and env vars merge by name instead
+// of by list index.
+
+type deploymentPatch struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   patchMetadata       `json:"metadata"`
+	Spec       deploymentPatchSpec `json:"spec"`
+}
+
+type patchMetadata struct {
+	Name string `json:"name"`
+}
+
+type deploymentPatchSpec struct {
+	Replicas *int              `json:"replicas,omitempty"`
+	Template *podTemplatePatch `json:"template,omitempty"`
+}
+
+type podTemplatePatch struct {
+	Spec podSpecPatch `json:"spec"`
+}
+
+type podSpecPatch struct {
+	Containers []containerPatch `json:"containers"`
+}
+
+type containerPatch struct {
+	Name      string                `json:"name"`
+	Resources *resourceRequirements `json:"resources,omitempty"`
+	Env       []envVar              `json:"env,omitempty"`
+}
+
+type resourceRequirements struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+type envVar struct {
+	Name      string        `json:"name"`
+	Value     string        `json:"value,omitempty"`
+	ValueFrom *envVarSource `json:"valueFrom,omitempty"`
+}
+
+type envVarSource struct {
+	SecretKeyRef *secretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+type secretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+func secretEnvVar(name, secretName, key string) envVar {
+	return envVar{
+		Name: name,
+		ValueFrom: &envVarSource{
+			SecretKeyRef: &secretKeySelector{Name: secretName, Key: key},
+		},
+	}
+}
+
+func newDeploymentPatch(app CatalogApp) deploymentPatch {
+	return deploymentPatch{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Metadata:   patchMetadata{Name: app.Deployment},
+	}
+}
+
+// withContainer sets the patch's single container entry, addressed by the
+// catalog app's container name.
+func (p *deploymentPatch) withContainer(app CatalogApp, container containerPatch) {
+	container.Name = defaultIfEmpty(app.Container, app.Deployment)
+	p.Spec.Template = &podTemplatePatch{
+		Spec: podSpecPatch{Containers: []containerPatch{container}},
+	}
+}
+
+func (c *ArgoCDClient) buildResourcePatch(app CatalogApp, req EnvironmentRequest) (ArgoCDKustomizePatch, error) {
+	patch := newDeploymentPatch(app)
+
+	if req.Replicas > 0 {
+		replicas := req.Replicas
+		patch.Spec.Replicas = &replicas
+	}
+
+	requests := make(map[string]string)
+	limits := make(map[string]string)
+	if req.CPU != "" {
+		requests["cpu"] = req.CPU
+	}
+	if req.Memory != "" {
+		requests["memory"] = req.Memory
+	}
+	if cpuLimit := defaultIfEmpty(req.CPULimit, req.CPU); cpuLimit != "" {
+		limits["cpu"] = cpuLimit
+	}
+	if memoryLimit := defaultIfEmpty(req.MemoryLimit, req.Memory); memoryLimit != "" {
+		limits["memory"] = memoryLimit
+	}
+	if len(requests) > 0 || len(limits) > 0 {
+		patch.withContainer(app, containerPatch{
+			Resources: &resourceRequirements{
+				Requests: requests,
+				Limits:   limits,
+			},
+		})
+	}
+
+	return marshalDeploymentPatch(patch)
+}
+
+func (c *ArgoCDClient) buildEnvVarsPatch(app CatalogApp, env []envVar) (ArgoCDKustomizePatch, error) {
+	patch := newDeploymentPatch(app)
+	patch.withContainer(app, containerPatch{Env: env})
+
+	return marshalDeploymentPatch(patch)
+}
+
+// mergeEnvVars overlays the user's env vars on top of generated ones and sorts
+// the result by name so the rendered Application is stable between calls.
+func mergeEnvVars(generated []envVar, userEnv map[string]string) []envVar {
+	byName := make(map[string]envVar, len(generated)+len(userEnv))
+	for _, env := range generated {
+		byName[env.Name] = env
+	}
+	for key, value := range userEnv {
+		byName[key] = envVar{Name: key, Value: value}
+	}
+
+	env := make([]envVar, 0, len(byName))
+	for _, e := range byName {
+		env = append(env, e)
+	}
+	sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+	return env
+}
+
+func marshalDeploymentPatch(patch deploymentPatch) (ArgoCDKustomizePatch, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return ArgoCDKustomizePatch{}, fmt.Errorf("failed to marshal %s patch: %w", patch.Metadata.Name, err)
+	}
+
+	return ArgoCDKustomizePatch{
+		Patch: string(data),
+		Target: ArgoCDKustomizeTarget{
+			Kind: "Deployment",
+			Name: patch.Metadata.Name,
+		},
+	}, nil
+}
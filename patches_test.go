@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzBuildEnvVarsPatch(f *testing.F) {
+	seeds := [][4]string{
+		{"DEBUG", "true", "LOG_LEVEL", "debug"},
+		{"QUOTED", `say "hi"`, "SINGLE", `it's`},
+		{"MULTILINE", "line one\nline two\r\n", "TAB", "a\tb"},
+		{"YAML", "key: value # comment", "DOC", "---\n...\n"},
+		{"FLOW", "{a: [1, 2]}", "ANCHOR", "&anchor *alias !!str"},
+		{"JSON", `{"nested": ["x", null]}`, "ESCAPES", `\n \" \\ A`},
+		{"SHELL", "${HOME} $(id) `id`", "HTML", "<script>&amp;</script>"},
+		{"EMPTY", "", "UNICODE", "héllo   世界 🚀"},
+		{"with space", "value", "with=equals", "a=b"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed[0], seed[1], seed[2], seed[3])
+	}
+
+	app := DefaultCatalog().Apps[0]
+	client := NewArgoCDClient("http://argocd.example.com", "token", DefaultCatalog())
+
+	f.Fuzz(func(t *testing.T, key1, value1, key2, value2 string) {
+		input := map[string]string{key1: value1, key2: value2}
+		for key, value := range input {
+			// JSON, and Kubernetes, can only carry valid UTF-8.
+			if !utf8.ValidString(key) || !utf8.ValidString(value) {
+				t.Skip()
+			}
+		}
+
+		patch, err := client.buildEnvVarsPatch(app, mergeEnvVars(nil, input))
+		if err != nil {
+			t.Fatalf("buildEnvVarsPatch: %v", err)
+		}
+
+		var decoded deploymentPatch
+		if err := json.Unmarshal([]byte(patch.Patch), &decoded); err != nil {
+			t.Fatalf("patch is not valid JSON: %v\n%s", err, patch.Patch)
+		}
+		if decoded.Spec.Template == nil || len(decoded.Spec.Template.Spec.Containers) != 1 {
+			t.Fatalf("patch has no container:\n%s", patch.Patch)
+		}
+
+		got := make(map[string]string)
+		for _, env := range decoded.Spec.Template.Spec.Containers[0].Env {
+			if _, dup := got[env.Name]; dup {
+				t.Fatalf("env var %q appears twice:\n%s", env.Name, patch.Patch)
+			}
+			got[env.Name] = env.Value
+		}
+		if !maps.Equal(got, input) {
+			t.Errorf("decoded env vars = %q, want %q", got, input)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Policy decides who may change an environment: its owner, or anyone in one
+// of the admin groups. With authentication disabled there is no caller to
+// check and everything is allowed.
+type Policy struct {
+	adminGroups []string
+}
+
+func NewPolicy(adminGroups []string) *Policy {
+	return &Policy{adminGroups: adminGroups}
+}
+
+// IsAdmin reports whether identity may change any environment.
+func (p *Policy) IsAdmin(identity *Identity) bool {
+	if identity == nil {
+		return false
+	}
+	for _, group := range identity.Groups {
+		if slices.Contains(p.adminGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize returns ErrForbidden unless identity may update, extend or
+// delete an environment owned by owner. Environments without an owner, such
+// as those created before authentication was enabled, are admin-only.
+func (p *Policy) Authorize(identity *Identity, owner string) error {
+	if identity == nil || p.IsAdmin(identity) {
+		return nil
+	}
+	if owner != "" && owner == identity.Name {
+		return nil
+	}
+	if owner == "" {
+		return fmt.Errorf("%w: only admins can change environments without an owner", ErrForbidden)
+	}
+	return fmt.Errorf("%w: environment is owned by %s", ErrForbidden, owner)
+}
+
+// Team returns the team a new environment created by identity counts
+// against: the requested team, which must be one of the caller's groups
+// unless they're an admin, or else the caller's first group.
+func (p *Policy) Team(identity *Identity, requested string) (string, error) {
+	if identity == nil {
+		return requested, nil
+	}
+	if requested == "" {
+		if len(identity.Groups) > 0 {
+			return identity.Groups[0], nil
+		}
+		return "", nil
+	}
+	if !slices.Contains(identity.Groups, requested) && !p.IsAdmin(identity) {
+		return "", fmt.Errorf("%w: %s is not a member of team %s", ErrForbidden, identity.Name, requested)
+	}
+	return requested, nil
+}
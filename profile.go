@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Profile is an environment type requests pick with env_type. It sets the
+// defaults, limits and Argo CD behavior for every environment of that type.
+type Profile struct {
+	Name string `yaml:"name" json:"name"`
+	// Label is shown in the UI; it defaults to Name.
+	Label string `yaml:"label,omitempty" json:"label,omitempty"`
+
+	// CPU, Memory and Replicas are used where a request leaves them unset,
+	// ahead of the app's catalog defaults.
+	CPU      string `yaml:"cpu,omitempty" json:"cpu,omitempty"`
+	Memory   string `yaml:"memory,omitempty" json:"memory,omitempty"`
+	Replicas int    `yaml:"replicas,omitempty" json:"replicas,omitempty"`
+
+	Bounds ResourceBounds `yaml:"bounds" json:"bounds"`
+	// AllowedDependencies narrows environments.allowed_dependencies for
+	// this type. Empty allows all of them.
+	AllowedDependencies []string `yaml:"allowed_dependencies,omitempty" json:"allowed_dependencies,omitempty"`
+	// EnvVars are set on every environment of this type; the request's own
+	// env_vars take precedence.
+	EnvVars map[string]string `yaml:"env_vars,omitempty" json:"env_vars,omitempty"`
+	// TTL is the expiry used when a request sets neither ttl nor
+	// expires_at. Empty means no expiry.
+	TTL string `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+
+	Sync    SyncProfile    `yaml:"sync" json:"sync"`
+	Ingress IngressProfile `yaml:"ingress" json:"ingress"`
+	// Namespace adds a ResourceQuota, LimitRange, NetworkPolicies and a Pod
+	// Security level to each environment's namespace. Unset leaves the
+	// namespace unconstrained.
+	Namespace *NamespaceProfile `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+}
+
+// SyncProfile is the Argo CD sync policy of an environment type. Without
+// Automated, changes wait for someone to sync them in Argo CD.
+type SyncProfile struct {
+	Automated bool `yaml:"automated" json:"automated"`
+	Prune     bool `yaml:"prune" json:"prune"`
+	SelfHeal  bool `yaml:"self_heal" json:"self_heal"`
+}
+
+// IngressProfile controls whether environments of a type are exposed at a
+// URL.
+type IngressProfile struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+}
+
+// The LimitRange defaults and quota headroom of the default profiles.
+var (
+	defaultContainerResources = ContainerResources{CPU: "100m", Memory: "128Mi", CPULimit: "500m", MemoryLimit: "512Mi"}
+	defaultHeadroom           = ContainerResources{CPU: "250m", Memory: "256Mi", CPULimit: "500m", MemoryLimit: "512Mi"}
+)
+
+// DefaultProfiles are the environment types used when the config file
+// doesn't define any.
+func DefaultProfiles() []Profile {
+	return []Profile{
+		{
+			Name:     "dev",
+			Label:    "Development",
+			Replicas: 1,
+			Bounds:   ResourceBounds{MinCPU: "10m", MaxCPU: "2", MinMemory: "16Mi", MaxMemory: "4Gi", MaxReplicas: 3},
+			EnvVars:  map[string]string{"LOG_LEVEL": "debug"},
+			TTL:      "72h",
+			Sync:     SyncProfile{Automated: true, Prune: true, SelfHeal: true},
+			Ingress:  IngressProfile{Enabled: true},
+			Namespace: &NamespaceProfile{
+				PodSecurity:       "baseline",
+				ContainerDefaults: defaultContainerResources,
+				Headroom:          defaultHeadroom,
+			},
+		},
+		{
+			Name:     "staging",
+			Label:    "Staging",
+			Replicas: 1,
+			Bounds:   ResourceBounds{MinCPU: "10m", MaxCPU: "4", MinMemory: "16Mi", MaxMemory: "8Gi", MaxReplicas: 5},
+			TTL:      "168h",
+			Sync:     SyncProfile{Automated: true, Prune: true, SelfHeal: true},
+			Ingress:  IngressProfile{Enabled: true},
+			Namespace: &NamespaceProfile{
+				PodSecurity:       "baseline",
+				ContainerDefaults: defaultContainerResources,
+				Headroom:          defaultHeadroom,
+			},
+		},
+		{
+			Name:     "prod",
+			Label:    "Production-like",
+			Replicas: 2,
+			Bounds:   ResourceBounds{MinCPU: "10m", MaxCPU: "8", MinMemory: "16Mi", MaxMemory: "16Gi", MaxReplicas: 10},
+			Sync:     SyncProfile{Automated: true, Prune: false, SelfHeal: true},
+			Ingress:  IngressProfile{Enabled: true},
+			Namespace: &NamespaceProfile{
+				PodSecurity:       "baseline",
+				ContainerDefaults: defaultContainerResources,
+				Headroom:          defaultHeadroom,
+			},
+		},
+	}
+}
+
+// DisplayName is the name shown in the UI.
+func (p Profile) DisplayName() string {
+	return defaultIfEmpty(p.Label, p.Name)
+}
+
+func (p Profile) validate(allowedDependencies []string) error {
+	if err := validateEnvType(p.Name); err != nil {
+		return fmt.Errorf("name: %w", err)
+	}
+	if p.CPU != "" {
+		if err := validateCPU(p.CPU); err != nil {
+			return fmt.Errorf("cpu: %w", err)
+		}
+	}
+	if p.Memory != "" {
+		if err := validateMemory(p.Memory); err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+	}
+	if p.Replicas < 0 || p.Replicas > 10 {
+		return fmt.Errorf("replicas must be between 0 and 10")
+	}
+	if err := p.Bounds.validate(); err != nil {
+		return fmt.Errorf("bounds: %w", err)
+	}
+	if err := p.Bounds.Check(EnvironmentRequest{CPU: p.CPU, Memory: p.Memory, Replicas: p.Replicas}); err != nil {
+		return fmt.Errorf("defaults are outside the bounds: %w", err)
+	}
+	for _, dep := range p.AllowedDependencies {
+		if !slices.Contains(allowedDependencies, dep) {
+			return fmt.Errorf("allowed_dependencies: %s is not in environments.allowed_dependencies", dep)
+		}
+	}
+	if p.TTL != "" {
+		if err := validateTTL(p.TTL); err != nil {
+			return fmt.Errorf("ttl: %w", err)
+		}
+	}
+	if p.Namespace != nil {
+		if err := p.Namespace.validate(p.Bounds); err != nil {
+			return fmt.Errorf("namespace: %w", err)
+		}
+	}
+	if !p.Sync.Automated && (p.Sync.Prune || p.Sync.SelfHeal) {
+		return fmt.Errorf("sync.prune and sync.self_heal require sync.automated")
+	}
+	return nil
+}
+
+// withDefaults fills in req from the profile where req leaves a setting
+// unset. The TTL is only filled in when withTTL is set, so a PATCH doesn't
+// add an expiry to an environment that was created without one.
+func (p Profile) withDefaults(req EnvironmentRequest, withTTL bool) EnvironmentRequest {
+	req.CPU = defaultIfEmpty(req.CPU, p.CPU)
+	req.Memory = defaultIfEmpty(req.Memory, p.Memory)
+	if req.Replicas == 0 {
+		req.Replicas = p.Replicas
+	}
+
+	if len(p.EnvVars) > 0 {
+		envVars := maps.Clone(p.EnvVars)
+		maps.Copy(envVars, req.EnvVars)
+		req.EnvVars = envVars
+	}
+
+	if withTTL && req.TTL == "" && req.ExpiresAt == nil {
+		req.TTL = p.TTL
+	}
+
+	return req
+}
+
+// syncPolicy renders the profile's Argo CD sync policy.
+func (p Profile) syncPolicy() *ArgoCDSyncPolicy {
+	policy := &ArgoCDSyncPolicy{
+		SyncOptions: []string{
+			"CreateNamespace=true",
+		},
+	}
+	if p.Sync.Automated {
+		policy.Automated = &ArgoCDAutomatedSync{
+			SelfHeal: p.Sync.SelfHeal,
+			Prune:    p.Sync.Prune,
+		}
+	}
+	return policy
+}
+
+// legacyProfile is used for Applications whose env-type has no profile,
+// such as ones created before profiles existed. It keeps the behavior they
+// were created with.
+var legacyProfile = Profile{
+	Sync:    SyncProfile{Automated: true, Prune: true, SelfHeal: true},
+	Ingress: IngressProfile{Enabled: true},
+}
+
+// findProfile returns the profile named envType, or the default profile when
+// envType is empty.
+func findProfile(profiles []Profile, defaultProfile, envType string) (Profile, bool) {
+	envType = defaultIfEmpty(envType, defaultProfile)
+	for _, p := range profiles {
+		if p.Name == envType {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// Kubernetes resource quantities: a decimal number followed by a binary SI
+// suffix (Ki, Mi, ...), a decimal SI suffix (m, k, M, ...) or a decimal
+// exponent (e3, E-2). See k8s.io/apimachinery/pkg/api/resource.
+var (
+	quantityRegex = regexp.MustCompile(`^([+-]?(?:[0-9]+(?:\.[0-9]*)?|\.[0-9]+))(.*)$`)
+	exponentRegex = regexp.MustCompile(`^[eE]([+-]?[0-9]+)$`)
+)
+
+var binarySuffixes = map[string]int64{
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+	"Pi": 1 << 50,
+	"Ei": 1 << 60,
+}
+
+var decimalSuffixes = map[string]int{
+	"n": -9,
+	"u": -6,
+	"m": -3,
+	"":  0,
+	"k": 3,
+	"M": 6,
+	"G": 9,
+	"T": 12,
+	"P": 15,
+	"E": 18,
+}
+
+// parseQuantity parses a quantity such as "500m", "1.5", "128Mi", "1e3" or
+// "2G" into its value in base units (cores for CPU, bytes for memory).
+func parseQuantity(s string) (*big.Rat, error) {
+	match := quantityRegex.FindStringSubmatch(s)
+	if match == nil {
+		return nil, fmt.Errorf("%q is not a valid quantity", s)
+	}
+	number, suffix := match[1], match[2]
+
+	value, ok := new(big.Rat).SetString(normalizeDecimal(number))
+	if !ok {
+		return nil, fmt.Errorf("%q is not a valid quantity", s)
+	}
+
+	if multiplier, ok := binarySuffixes[suffix]; ok {
+		return value.Mul(value, new(big.Rat).SetInt64(multiplier)), nil
+	}
+
+	exponent, ok := decimalSuffixes[suffix]
+	if !ok {
+		m := exponentRegex.FindStringSubmatch(suffix)
+		if m == nil {
+			return nil, fmt.Errorf("%q has an unknown suffix %q", s, suffix)
+		}
+		e, err := strconv.Atoi(m[1])
+		if err != nil || e < -30 || e > 30 {
+			return nil, fmt.Errorf("%q has an out of range exponent", s)
+		}
+		exponent = e
+	}
+
+	return value.Mul(value, pow10(exponent)), nil
+}
+
+// normalizeDecimal rewrites ".5" and "5." as "0.5" and "5" for big.Rat.
+func normalizeDecimal(number string) string {
+	sign := ""
+	if number[0] == '+' || number[0] == '-' {
+		sign, number = number[:1], number[1:]
+	}
+	if number[0] == '.' {
+		number = "0" + number
+	}
+	if number[len(number)-1] == '.' {
+		number = number[:len(number)-1]
+	}
+	return sign + number
+}
+
+func pow10(exponent int) *big.Rat {
+	abs := exponent
+	if abs < 0 {
+		abs = -abs
+	}
+	p := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs)), nil)
+	if exponent < 0 {
+		return new(big.Rat).SetFrac(big.NewInt(1), p)
+	}
+	return new(big.Rat).SetInt(p)
+}
+
+// formatCPU renders cores as whole cores when exact, otherwise as millicores
+// rounded up, e.g. 2 -> "2", 1.5 -> "1500m".
+func formatCPU(cores *big.Rat) string {
+	if cores.IsInt() {
+		return cores.Num().String()
+	}
+	milli := new(big.Rat).Mul(cores, big.NewRat(1000, 1))
+	return ceilRat(milli).String() + "m"
+}
+
+// formatMemory renders bytes with the largest binary suffix that divides
+// them exactly, e.g. 1610612736 -> "1536Mi".
+func formatMemory(bytes *big.Rat) string {
+	whole := ceilRat(bytes)
+	if whole.Sign() == 0 {
+		return "0"
+	}
+	for _, suffix := range []string{"Ei", "Pi", "Ti", "Gi", "Mi", "Ki"} {
+		unit := big.NewInt(binarySuffixes[suffix])
+		quotient, remainder := new(big.Int).QuoRem(whole, unit, new(big.Int))
+		if remainder.Sign() == 0 {
+			return quotient.String() + suffix
+		}
+	}
+	return whole.String()
+}
+
+func ceilRat(r *big.Rat) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient
+}
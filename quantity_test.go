@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // as a big.Rat fraction
+	}{
+		{"0", "0"},
+		{"2", "2"},
+		{"1.5", "3/2"},
+		{".5", "1/2"},
+		{"5.", "5"},
+		{"+3", "3"},
+		{"-1", "-1"},
+		{"-.5", "-1/2"},
+		{"500m", "1/2"},
+		{"1m", "1/1000"},
+		{"0.5m", "1/2000"},
+		{"1500m", "3/2"},
+		{"100n", "1/10000000"},
+		{"2u", "1/500000"},
+		{"1k", "1000"},
+		{"1M", "1000000"},
+		{"2G", "2000000000"},
+		{"1T", "1000000000000"},
+		{"1P", "1000000000000000"},
+		{"1E", "1000000000000000000"},
+		{"1Ki", "1024"},
+		{"128Mi", "134217728"},
+		{"1.5Gi", "1610612736"},
+		{"1Ti", "1099511627776"},
+		{"1Pi", "1125899906842624"},
+		{"1Ei", "1152921504606846976"},
+		{"1e3", "1000"},
+		{"1E3", "1000"},
+		{"2e-3", "1/500"},
+		{"1.5e+2", "150"},
+		{"-2Ki", "-2048"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseQuantity(tt.in)
+			if err != nil {
+				t.Fatalf("parseQuantity(%q): %v", tt.in, err)
+			}
+			want, _ := new(big.Rat).SetString(tt.want)
+			if got.Cmp(want) != 0 {
+				t.Errorf("parseQuantity(%q) = %s, want %s", tt.in, got.RatString(), want.RatString())
+			}
+		})
+	}
+}
+
+func TestParseQuantityInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"m",
+		"Gi",
+		".",
+		"-",
+		"1.2.3",
+		"1 Gi",
+		"1gi",
+		"1KiB",
+		"1K",
+		"1mi",
+		"1e",
+		"1e3.5",
+		"1e31",
+		"1e-31",
+		"0x10",
+		"one",
+	} {
+		if got, err := parseQuantity(in); err == nil {
+			t.Errorf("parseQuantity(%q) = %s, want an error", in, got.RatString())
+		}
+	}
+}
+
+func TestFormatQuantities(t *testing.T) {
+	cpu := []struct {
+		in   *big.Rat
+		want string
+	}{
+		{big.NewRat(2, 1), "2"},
+		{big.NewRat(3, 2), "1500m"},
+		{big.NewRat(1, 3), "334m"},
+		{big.NewRat(0, 1), "0"},
+	}
+	for _, tt := range cpu {
+		if got := formatCPU(tt.in); got != tt.want {
+			t.Errorf("formatCPU(%s) = %q, want %q", tt.in.RatString(), got, tt.want)
+		}
+	}
+
+	memory := []struct {
+		in   *big.Rat
+		want string
+	}{
+		{big.NewRat(1610612736, 1), "1536Mi"},
+		{big.NewRat(1<<30, 1), "1Gi"},
+		{big.NewRat(1000, 1), "1000"},
+		{big.NewRat(1, 2), "1"},
+		{big.NewRat(0, 1), "0"},
+	}
+	for _, tt := range memory {
+		if got := formatMemory(tt.in); got != tt.want {
+			t.Errorf("formatMemory(%s) = %q, want %q", tt.in.RatString(), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// teamLabel and teamAnnotation record the team an environment counts
+// against, like ownerLabel and ownerAnnotation.
+const (
+	teamLabel      = "team"
+	teamAnnotation = "meeseeks.io/team"
+)
+
+// QuotaLimits caps what one owner or team may use across all of their
+// environments. CPU and memory are summed as the request times replicas.
+// Zero or empty values are unlimited.
+type QuotaLimits struct {
+	MaxEnvironments int    `yaml:"max_environments" json:"max_environments,omitempty"`
+	MaxCPU          string `yaml:"max_cpu" json:"max_cpu,omitempty"`
+	MaxMemory       string `yaml:"max_memory" json:"max_memory,omitempty"`
+}
+
+func (l QuotaLimits) validate() error {
+	if l.MaxEnvironments < 0 {
+		return fmt.Errorf("max_environments cannot be negative")
+	}
+	for name, value := range map[string]string{"max_cpu": l.MaxCPU, "max_memory": l.MaxMemory} {
+		if value == "" {
+			continue
+		}
+		quantity, err := parseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if quantity.Sign() <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// QuotaConfig sets the limits for every owner and every team, with
+// per-team overrides.
+type QuotaConfig struct {
+	Owner QuotaLimits            `yaml:"owner"`
+	Team  QuotaLimits            `yaml:"team"`
+	Teams map[string]QuotaLimits `yaml:"teams"`
+}
+
+func (c QuotaConfig) teamLimits(team string) QuotaLimits {
+	if limits, ok := c.Teams[team]; ok {
+		return limits
+	}
+	return c.Team
+}
+
+// QuotaExceededError reports which limit a create or update would exceed.
+type QuotaExceededError struct {
+	Scope    string
+	Name     string
+	Resource string
+	Used     string
+	Limit    string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota exceeded for %s %s: would use %s, limit is %s", e.Resource, e.Scope, e.Name, e.Used, e.Limit)
+}
+
+// reservationGrace is how long a finished create or update keeps counting
+// against quotas, so a listing that started before it finished and doesn't
+// show it yet can't be used to slip under a limit. It covers an ArgoCD call
+// that runs to its timeout.
+const reservationGrace = 2 * time.Minute
+
+// Quotas enforces QuotaConfig. Usage is computed from the environments
+// ArgoCD reports, so it reflects changes made outside meeseeks too.
+type Quotas struct {
+	config  QuotaConfig
+	catalog *Catalog
+	now     func() time.Time
+	// mu guards reservations. It is only held to check and record a
+	// reservation, never across a call to ArgoCD.
+	mu sync.Mutex
+	// reservations are the creates and updates that passed the check but
+	// that ArgoCD listings may not show yet.
+	reservations []*reservation
+}
+
+// reservation is an environment as a create or update will leave it.
+type reservation struct {
+	item EnvironmentItem
+	// released is when the create or update finished, or zero while it is
+	// in flight.
+	released time.Time
+}
+
+func NewQuotas(config QuotaConfig, catalog *Catalog) *Quotas {
+	return &Quotas{config: config, catalog: catalog, now: time.Now}
+}
+
+// quotaUsage is what a set of environments uses.
+type quotaUsage struct {
+	environments int
+	cpu          *big.Rat
+	memory       *big.Rat
+}
+
+func newQuotaUsage() quotaUsage {
+	return quotaUsage{cpu: new(big.Rat), memory: new(big.Rat)}
+}
+
+// add counts one environment. A replica count of zero leaves the manifest's
+// replicas in place, counted here as one; an unset or unparseable CPU or
+// memory counts as zero.
+func (u *quotaUsage) add(cpu, memory string, replicas int) {
+	u.environments++
+	replicas = max(replicas, 1)
+	if q, err := parseQuantity(cpu); err == nil {
+		u.cpu.Add(u.cpu, q.Mul(q, big.NewRat(int64(replicas), 1)))
+	}
+	if q, err := parseQuantity(memory); err == nil {
+		u.memory.Add(u.memory, q.Mul(q, big.NewRat(int64(replicas), 1)))
+	}
+}
+
+func (q *Quotas) tally(items []EnvironmentItem, match func(EnvironmentItem) bool, exclude string) quotaUsage {
+	usage := newQuotaUsage()
+	for _, item := range items {
+		if item.Name != exclude && match(item) {
+			usage.add(item.CPU, item.Memory, item.Replicas)
+		}
+	}
+	return usage
+}
+
+// Reserve checks that req fits within its owner's and team's quotas,
+// counting the other reservations ArgoCD may not show yet. On success the
+// caller must call release once the create or update is done, reporting
+// whether it succeeded; a failed one stops counting right away.
+func (q *Quotas) Reserve(ctx context.Context, client ArgoCDClientInterface, req EnvironmentRequest) (release func(succeeded bool), err error) {
+	listed := q.now()
+	environments, err := client.ListApplications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check quotas: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.check(q.withReservations(environments.Items, listed), req); err != nil {
+		return nil, err
+	}
+
+	defaulted := q.catalog.WithDefaults(req)
+	r := &reservation{item: EnvironmentItem{
+		Name:     req.Name,
+		Owner:    req.Owner,
+		Team:     req.Team,
+		CPU:      defaulted.CPU,
+		Memory:   defaulted.Memory,
+		Replicas: defaulted.Replicas,
+	}}
+	q.reservations = append(q.reservations, r)
+
+	return func(succeeded bool) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if !succeeded {
+			q.reservations = slices.DeleteFunc(q.reservations, func(other *reservation) bool { return other == r })
+			return
+		}
+		r.released = q.now()
+	}, nil
+}
+
+// withReservations returns items, listed at the given time, with each
+// environment that has a reservation in flight, or released since, replaced
+// by the reserved one. Reservations past their grace period are dropped.
+func (q *Quotas) withReservations(items []EnvironmentItem, listed time.Time) []EnvironmentItem {
+	q.reservations = slices.DeleteFunc(q.reservations, func(r *reservation) bool {
+		return !r.released.IsZero() && q.now().Sub(r.released) > reservationGrace
+	})
+
+	merged := slices.Clone(items)
+	for _, r := range q.reservations {
+		if !r.released.IsZero() && r.released.Before(listed) {
+			continue
+		}
+		merged = slices.DeleteFunc(merged, func(item EnvironmentItem) bool { return item.Name == r.item.Name })
+		merged = append(merged, r.item)
+	}
+	return merged
+}
+
+// check rejects req if replacing any environment of the same name with it
+// would take its owner or team over a limit. A change that doesn't increase
+// usage is always allowed, so lowering a limit doesn't block shrinking.
+func (q *Quotas) check(items []EnvironmentItem, req EnvironmentRequest) error {
+	req = q.catalog.WithDefaults(req)
+
+	scopes := []struct {
+		scope  string
+		name   string
+		limits QuotaLimits
+		match  func(EnvironmentItem) bool
+	}{
+		{"owner", req.Owner, q.config.Owner, func(item EnvironmentItem) bool { return item.Owner == req.Owner }},
+		{"team", req.Team, q.config.teamLimits(req.Team), func(item EnvironmentItem) bool { return item.Team == req.Team }},
+	}
+
+	for _, s := range scopes {
+		if s.name == "" {
+			continue
+		}
+
+		before := q.tally(items, s.match, "")
+		after := q.tally(items, s.match, req.Name)
+		after.add(req.CPU, req.Memory, req.Replicas)
+
+		if err := exceeds(s.scope, s.name, s.limits, before, after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func exceeds(scope, name string, limits QuotaLimits, before, after quotaUsage) error {
+	exceeded := func(resource, used, limit string) error {
+		return &QuotaExceededError{Scope: scope, Name: name, Resource: resource, Used: used, Limit: limit}
+	}
+
+	if limits.MaxEnvironments > 0 && after.environments > limits.MaxEnvironments && after.environments > before.environments {
+		return exceeded("environments", strconv.Itoa(after.environments), strconv.Itoa(limits.MaxEnvironments))
+	}
+
+	if limit, err := parseQuantity(limits.MaxCPU); err == nil && after.cpu.Cmp(limit) > 0 && after.cpu.Cmp(before.cpu) > 0 {
+		return exceeded("CPU", formatCPU(after.cpu), limits.MaxCPU)
+	}
+
+	if limit, err := parseQuantity(limits.MaxMemory); err == nil && after.memory.Cmp(limit) > 0 && after.memory.Cmp(before.memory) > 0 {
+		return exceeded("memory", formatMemory(after.memory), limits.MaxMemory)
+	}
+
+	return nil
+}
+
+// QuotaUsage is one owner's or team's usage against its limits.
+type QuotaUsage struct {
+	Scope        string      `json:"scope"`
+	Name         string      `json:"name"`
+	Environments int         `json:"environments"`
+	CPU          string      `json:"cpu"`
+	Memory       string      `json:"memory"`
+	Limits       QuotaLimits `json:"limits"`
+}
+
+// Usage reports identity's own usage and that of each of its groups.
+func (q *Quotas) Usage(items []EnvironmentItem, identity *Identity) []QuotaUsage {
+	report := func(scope, name string, limits QuotaLimits, match func(EnvironmentItem) bool) QuotaUsage {
+		usage := q.tally(items, match, "")
+		return QuotaUsage{
+			Scope:        scope,
+			Name:         name,
+			Environments: usage.environments,
+			CPU:          formatCPU(usage.cpu),
+			Memory:       formatMemory(usage.memory),
+			Limits:       limits,
+		}
+	}
+
+	usages := []QuotaUsage{
+		report("owner", identity.Name, q.config.Owner, func(item EnvironmentItem) bool { return item.Owner == identity.Name }),
+	}
+	for _, group := range identity.Groups {
+		usages = append(usages, report("team", group, q.config.teamLimits(group), func(item EnvironmentItem) bool { return item.Team == group }))
+	}
+	return usages
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQuotaReservations(t *testing.T) {
+	reserve := func(t *testing.T, quotas *Quotas, client ArgoCDClientInterface, name string) (func(bool), error) {
+		t.Helper()
+		type result struct {
+			release func(bool)
+			err     error
+		}
+		done := make(chan result, 1)
+		go func() {
+			release, err := quotas.Reserve(context.Background(), client, EnvironmentRequest{Name: name, Branch: "main", Owner: "jane"})
+			done <- result{release, err}
+		}()
+		select {
+		case r := <-done:
+			return r.release, r.err
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Reserve(%s) blocked behind another reservation", name)
+			return nil, nil
+		}
+	}
+	wantExceeded := func(t *testing.T, err error) {
+		t.Helper()
+		var exceeded *QuotaExceededError
+		if !errors.As(err, &exceeded) || exceeded.Resource != "environments" {
+			t.Fatalf("Reserve error = %v, want an environments quota error", err)
+		}
+	}
+
+	setup := func() (*Quotas, *MockArgoCDClient, *fakeClock) {
+		clock := newFakeClock()
+		quotas := NewQuotas(QuotaConfig{Owner: QuotaLimits{MaxEnvironments: 1}}, DefaultCatalog())
+		quotas.now = clock.Now
+		return quotas, NewMockArgoCDClient(DefaultCatalog()), clock
+	}
+
+	t.Run("in flight counts", func(t *testing.T) {
+		quotas, client, _ := setup()
+		if _, err := reserve(t, quotas, client, "first"); err != nil {
+			t.Fatalf("first Reserve: %v", err)
+		}
+		_, err := reserve(t, quotas, client, "second")
+		wantExceeded(t, err)
+	})
+
+	t.Run("released counts until listings catch up", func(t *testing.T) {
+		quotas, client, clock := setup()
+		release, err := reserve(t, quotas, client, "first")
+		if err != nil {
+			t.Fatalf("first Reserve: %v", err)
+		}
+		clock.Advance(time.Second)
+		release(true)
+
+		// The mock never got the create, like a listing that started
+		// before it finished.
+		_, err = reserve(t, quotas, client, "second")
+		wantExceeded(t, err)
+
+		clock.Advance(reservationGrace + time.Second)
+		if _, err := reserve(t, quotas, client, "second"); err != nil {
+			t.Errorf("Reserve after the grace period: %v", err)
+		}
+	})
+
+	t.Run("failed is dropped", func(t *testing.T) {
+		quotas, client, _ := setup()
+		release, err := reserve(t, quotas, client, "first")
+		if err != nil {
+			t.Fatalf("first Reserve: %v", err)
+		}
+		release(false)
+
+		if _, err := reserve(t, quotas, client, "second"); err != nil {
+			t.Errorf("Reserve after a failed create: %v", err)
+		}
+	})
+
+	t.Run("same name replaces", func(t *testing.T) {
+		quotas, client, _ := setup()
+		if _, err := reserve(t, quotas, client, "first"); err != nil {
+			t.Fatalf("first Reserve: %v", err)
+		}
+		if _, err := reserve(t, quotas, client, "first"); err != nil {
+			t.Errorf("second Reserve of the same environment: %v", err)
+		}
+	})
+}
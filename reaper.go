@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// expiresAtAnnotation holds the RFC 3339 time after which the reaper deletes
+// an Application.
+const expiresAtAnnotation = "meeseeks.io/expires-at"
+
+// Reaper periodically deletes meeseeks-managed environments whose expiry has
+// passed.
+type Reaper struct {
+	client   ArgoCDClientInterface
+	interval time.Duration
+	now      func() time.Time
+}
+
+func NewReaper(client ArgoCDClientInterface, interval time.Duration) *Reaper {
+	return &Reaper{
+		client:   client,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run reaps once per interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Reap(ctx)
+		}
+	}
+}
+
+// Reap deletes every expired environment and returns the names it deleted.
+// A failed delete is logged and retried on the next pass.
+func (r *Reaper) Reap(ctx context.Context) []string {
+	environments, err := r.client.ListApplications(ctx)
+	if err != nil {
+		log.Printf("Reaper: failed to list environments: %v", err)
+		return nil
+	}
+
+	now := r.now()
+	var deleted []string
+	for _, env := range environments.Items {
+		if env.ExpiresAt == nil || env.ExpiresAt.After(now) {
+			continue
+		}
+
+		if err := r.client.DeleteApplication(ctx, env.Name); err != nil {
+			log.Printf("Reaper: failed to delete expired environment %s: %v", env.Name, err)
+			continue
+		}
+
+		log.Printf("Reaper: deleted environment %s (expired at %s)", env.Name, env.ExpiresAt.Format(time.RFC3339))
+		deleted = append(deleted, env.Name)
+	}
+
+	return deleted
+}
+
+// resolveExpiry turns a request's TTL into an absolute ExpiresAt. An explicit
+// ExpiresAt is kept as long as it is in the future.
+func resolveExpiry(req EnvironmentRequest, now time.Time) (EnvironmentRequest, error) {
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return req, ValidationErrors{{Field: "expires_at", Code: codeOutOfRange, Message: "must be in the future"}}
+		}
+		return req, nil
+	}
+
+	if req.TTL == "" {
+		return req, nil
+	}
+
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		return req, ValidationErrors{{Field: "ttl", Code: codeInvalidFormat, Message: err.Error()}}
+	}
+
+	expiresAt := now.Add(ttl).UTC().Truncate(time.Second)
+	req.ExpiresAt = &expiresAt
+	return req, nil
+}
+
+// extendExpiry adds ttl to the current expiry, or to now if the environment
+// has no expiry or it has already passed.
+func extendExpiry(current *time.Time, ttl time.Duration, now time.Time) time.Time {
+	base := now
+	if current != nil && current.After(now) {
+		base = *current
+	}
+	return base.Add(ttl).UTC().Truncate(time.Second)
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func parseExpiresAt(value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
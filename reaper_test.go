@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func createExpiring(t *testing.T, client ArgoCDClientInterface, name string, expiresAt *time.Time) {
+	t.Helper()
+	req := EnvironmentRequest{Name: name, Branch: "main", ExpiresAt: expiresAt}
+	if _, err := client.CreateApplication(context.Background(), req); err != nil {
+		t.Fatalf("failed to create %s: %v", name, err)
+	}
+}
+
+func environmentNames(t *testing.T, client ArgoCDClientInterface) []string {
+	t.Helper()
+	list, err := client.ListApplications(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list environments: %v", err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestReaperDeletesOnlyExpiredEnvironments(t *testing.T) {
+	mock := NewMockArgoCDClient(DefaultCatalog())
+	past := testNow.Add(-time.Minute)
+	future := testNow.Add(time.Hour)
+	createExpiring(t, mock, "expired", &past)
+	createExpiring(t, mock, "unexpired", &future)
+	createExpiring(t, mock, "forever", nil)
+
+	reaper := NewReaper(mock, time.Minute)
+	reaper.now = func() time.Time { return testNow }
+
+	if deleted := reaper.Reap(context.Background()); !slices.Equal(deleted, []string{"expired"}) {
+		t.Errorf("Reap deleted %v, want [expired]", deleted)
+	}
+	if got, want := environmentNames(t, mock), []string{"forever", "unexpired"}; !slices.Equal(got, want) {
+		t.Errorf("environments after reaping = %v, want %v", got, want)
+	}
+}
+
+func TestReaperContinuesAfterDeleteError(t *testing.T) {
+	mock := NewMockArgoCDClient(DefaultCatalog())
+	past := testNow.Add(-time.Minute)
+	createExpiring(t, mock, "first", &past)
+	createExpiring(t, mock, "second", &past)
+	mock.FailNext("DeleteApplication", errors.New("ArgoCD is down"))
+
+	reaper := NewReaper(mock, time.Minute)
+	reaper.now = func() time.Time { return testNow }
+
+	if deleted := reaper.Reap(context.Background()); !slices.Equal(deleted, []string{"second"}) {
+		t.Errorf("Reap deleted %v, want [second]", deleted)
+	}
+	// The failed delete is retried on the next pass.
+	if deleted := reaper.Reap(context.Background()); !slices.Equal(deleted, []string{"first"}) {
+		t.Errorf("second Reap deleted %v, want [first]", deleted)
+	}
+}
+
+func TestResolveExpiry(t *testing.T) {
+	explicit := testNow.Add(2 * time.Hour)
+	past := testNow.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		req     EnvironmentRequest
+		want    *time.Time
+		wantErr string
+	}{
+		{name: "no expiry", req: EnvironmentRequest{}},
+		{name: "ttl", req: EnvironmentRequest{TTL: "72h"}, want: ptr(testNow.Add(72 * time.Hour))},
+		{name: "expires_at wins over ttl", req: EnvironmentRequest{TTL: "72h", ExpiresAt: &explicit}, want: &explicit},
+		{name: "expires_at in the past", req: EnvironmentRequest{ExpiresAt: &past}, wantErr: "expires_at"},
+		{name: "invalid ttl", req: EnvironmentRequest{TTL: "soon"}, wantErr: "ttl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveExpiry(tt.req, testNow)
+			if tt.wantErr != "" {
+				var errs ValidationErrors
+				if !errors.As(err, &errs) || !errs.Has(tt.wantErr) {
+					t.Fatalf("resolveExpiry error = %v, want a %s error", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveExpiry: %v", err)
+			}
+			if !sameTime(got.ExpiresAt, tt.want) {
+				t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendExpiry(t *testing.T) {
+	later := testNow.Add(time.Hour)
+	earlier := testNow.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		current *time.Time
+		want    time.Time
+	}{
+		{name: "from current expiry", current: &later, want: later.Add(24 * time.Hour)},
+		{name: "from now once expired", current: &earlier, want: testNow.Add(24 * time.Hour)},
+		{name: "from now without expiry", want: testNow.Add(24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extendExpiry(tt.current, 24*time.Hour, testNow); !got.Equal(tt.want) {
+				t.Errorf("extendExpiry = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreatePrefersExpiresAtOverTTL(t *testing.T) {
+	api, mock := newTestAPI(t)
+	api.now = func() time.Time { return testNow }
+
+	body := `{"name": "both", "branch": "main", "ttl": "1h", "expires_at": "2026-03-05T00:00:00Z"}`
+	rec := httptest.NewRecorder()
+	api.createEnvironment(rec, httptest.NewRequest(http.MethodPost, "/environments", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create returned %d: %s", rec.Code, rec.Body)
+	}
+
+	app, _ := mock.Application("both")
+	if got := app.Metadata.Annotations[expiresAtAnnotation]; got != "2026-03-05T00:00:00Z" {
+		t.Errorf("expires-at annotation = %q, want 2026-03-05T00:00:00Z", got)
+	}
+}
+
+func TestExtendPushesExpiry(t *testing.T) {
+	api, mock := newTestAPI(t)
+	api.now = func() time.Time { return testNow }
+	expiresAt := testNow.Add(time.Hour)
+	createExpiring(t, mock, "extend-me", &expiresAt)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/environments/extend-me/extend", strings.NewReader(`{"ttl": "24h"}`))
+	api.extendEnvironment(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("extend returned %d: %s", rec.Code, rec.Body)
+	}
+
+	var response struct {
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	want := expiresAt.Add(24 * time.Hour)
+	if !response.ExpiresAt.Equal(want) {
+		t.Errorf("response expires_at = %v, want %v", response.ExpiresAt, want)
+	}
+
+	app, _ := mock.Application("extend-me")
+	if got := app.Metadata.Annotations[expiresAtAnnotation]; got != want.Format(time.RFC3339) {
+		t.Errorf("expires-at annotation = %q, want %s", got, want.Format(time.RFC3339))
+	}
+
+	// Once the new expiry has passed, the reaper removes the environment.
+	reaper := NewReaper(mock, time.Minute)
+	reaper.now = func() time.Time { return want.Add(time.Second) }
+	if deleted := reaper.Reap(context.Background()); !slices.Equal(deleted, []string{"extend-me"}) {
+		t.Errorf("Reap deleted %v, want [extend-me]", deleted)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestPatchExpiry(t *testing.T) {
+	expiresAt := testNow.Add(time.Hour)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "past expires_at is rejected",
+			body:       `{"expires_at": "2020-01-01T00:00:00Z"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       expiresAt.Format(time.RFC3339),
+		},
+		{
+			name:       "expires_at wins over ttl",
+			body:       `{"ttl": "1h", "expires_at": "2026-03-05T00:00:00Z"}`,
+			wantStatus: http.StatusOK,
+			want:       "2026-03-05T00:00:00Z",
+		},
+		{
+			name:       "new ttl counts from now",
+			body:       `{"ttl": "3h"}`,
+			wantStatus: http.StatusOK,
+			want:       testNow.Add(3 * time.Hour).Format(time.RFC3339),
+		},
+		{
+			name:       "other changes keep the expiry",
+			body:       `{"env_vars": {"DEBUG": "true"}}`,
+			wantStatus: http.StatusOK,
+			want:       expiresAt.Format(time.RFC3339),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, mock := newTestAPI(t)
+			api.now = func() time.Time { return testNow }
+			createExpiring(t, mock, "patched", &expiresAt)
+
+			rec := httptest.NewRecorder()
+			api.updateEnvironment(rec, httptest.NewRequest(http.MethodPatch, "/environments/patched", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("PATCH returned %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			app, _ := mock.Application("patched")
+			if got := app.Metadata.Annotations[expiresAtAnnotation]; got != tt.want {
+				t.Errorf("expires-at annotation = %q, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling ArgoCD while the circuit
+// breaker considers it down.
+var ErrCircuitOpen = errors.New("ArgoCD circuit breaker is open")
+
+// RetryPolicy controls how failed ArgoCD calls are retried. Idempotent calls
+// are retried on connection errors and 5xx responses; others only when the
+// connection couldn't be established, since the request may otherwise have
+// been applied.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt; 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// backoff returns a delay for the given attempt (1-based) using exponential
+// backoff with full jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << (attempt - 1)
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryDelay decides whether an attempt should be retried and how long to
+// wait first. A Retry-After header on the response takes precedence over the
+// computed backoff, capped at MaxDelay.
+func (p RetryPolicy) retryDelay(resp *http.Response, err error, idempotent bool, attempt int) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+
+	if err != nil {
+		if !idempotent && !isDialError(err) {
+			return 0, false
+		}
+		return p.backoff(attempt), true
+	}
+
+	if resp.StatusCode < 500 || !idempotent {
+		return 0, false
+	}
+
+	if after, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+		return min(after, p.MaxDelay), true
+	}
+	return p.backoff(attempt), true
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		delay := time.Until(t)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// CircuitBreaker stops calls to ArgoCD after a run of consecutive failures
+// and lets a single probe through once the cooldown has passed.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     circuitClosed,
+	}
+}
+
+// Allow reports whether a call may proceed.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return nil
+	case circuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// Record updates the breaker with the outcome of an allowed call.
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		if b.state != circuitClosed {
+			log.Printf("ArgoCD circuit breaker closed")
+		}
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.threshold {
+		if b.state != circuitOpen {
+			log.Printf("ArgoCD circuit breaker opened after %d consecutive failures", b.failures)
+		}
+		b.state = circuitOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Abandon releases a half-open probe whose outcome is unknown, e.g. because
+// the caller gave up, without counting it as a success or failure.
+func (b *CircuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// CircuitStatus is the breaker state reported by the health endpoint.
+type CircuitStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+}
+
+func (b *CircuitBreaker) Status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != circuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// do sends req through the circuit breaker, retrying according to the
+// client's retry policy. Requests with a body must be created with a body
+// type http.NewRequest knows how to replay.
+func (c *ArgoCDClient) do(req *http.Request, idempotent bool) (*http.Response, error) {
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				resp, err = nil, fmt.Errorf("failed to replay request body: %w", bodyErr)
+				break
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err = c.client.Do(attemptReq)
+
+		delay, retry := c.retry.retryDelay(resp, err, idempotent, attempt)
+		if !retry {
+			break
+		}
+
+		if err != nil {
+			log.Printf("ArgoCD %s %s failed (attempt %d/%d): %v; retrying in %s",
+				req.Method, req.URL.Path, attempt, c.retry.MaxAttempts, err, delay)
+		} else {
+			log.Printf("ArgoCD %s %s returned %d (attempt %d/%d); retrying in %s",
+				req.Method, req.URL.Path, resp.StatusCode, attempt, c.retry.MaxAttempts, delay)
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			c.breaker.Abandon()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	// A call the caller cancelled says nothing about ArgoCD's health.
+	if err != nil && req.Context().Err() != nil {
+		c.breaker.Abandon()
+		return nil, err
+	}
+
+	c.breaker.Record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
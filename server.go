@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerTimeouts bounds how long a client may take to send a request or read
+// a response, and how long shutdown waits for in-flight requests.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	Shutdown   time.Duration
+}
+
+func DefaultServerTimeouts() ServerTimeouts {
+	return ServerTimeouts{
+		ReadHeader: 5 * time.Second,
+		Read:       30 * time.Second,
+		Write:      2 * time.Minute,
+		Idle:       2 * time.Minute,
+		Shutdown:   30 * time.Second,
+	}
+}
+
+// Workers runs background goroutines that live as long as the server, such
+// as the reaper. Each one receives a context that is cancelled when shutdown
+// starts and is expected to return promptly after that.
+type Workers struct {
+	wg sync.WaitGroup
+}
+
+// Go starts fn in a goroutine tracked by w.
+func (w *Workers) Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(ctx)
+		log.Printf("Background worker %s stopped", name)
+	}()
+}
+
+// Wait blocks until every worker has returned or ctx is done.
+func (w *Workers) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newServer wraps handler in an http.Server with the given timeouts. Request
+// contexts derive from base, so cancelling base aborts requests that are
+// still running once the shutdown deadline has passed.
+func newServer(addr string, handler http.Handler, timeouts ServerTimeouts, base context.Context) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: timeouts.ReadHeader,
+		ReadTimeout:       timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+}
+
+// serve runs the server and workers until ctx is cancelled, then stops
+// accepting connections, waits up to timeouts.Shutdown for in-flight requests
+// and workers to finish, and forcibly closes whatever is left.
+func serve(ctx context.Context, addr string, handler http.Handler, timeouts ServerTimeouts, start func(ctx context.Context, workers *Workers)) error {
+	requests, abortRequests := context.WithCancel(context.Background())
+	defer abortRequests()
+
+	server := newServer(addr, handler, timeouts, requests)
+
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	var workers Workers
+	if start != nil {
+		start(workerCtx, &workers)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		stopWorkers()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, draining requests for up to %s", timeouts.Shutdown)
+	stopWorkers()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeouts.Shutdown)
+	defer cancel()
+
+	var shutdownErr error
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown deadline exceeded, aborting remaining requests: %v", err)
+		abortRequests()
+		server.Close()
+		shutdownErr = err
+	}
+
+	if err := workers.Wait(shutdownCtx); err != nil {
+		log.Printf("Background workers did not stop before the shutdown deadline: %v", err)
+		shutdownErr = errors.Join(shutdownErr, err)
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return shutdownErr
+}
@@ -1,64 +1,169 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 )
 
-var (
-	nameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
-	cpuRegex  = regexp.MustCompile(`^\d+(\.\d+)?[m]?$`)
-	memRegex  = regexp.MustCompile(`^\d+(\.\d+)?[KMGT]i?$`)
+var nameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Validation error codes. Clients can match on these; the messages are for
+// people.
+const (
+	codeRequired      = "required"
+	codeTooLong       = "too_long"
+	codeInvalidFormat = "invalid_format"
+	codeOutOfRange    = "out_of_range"
+	codeNotAllowed    = "not_allowed"
+	codeUnknown       = "unknown"
+	codeBelowRequest  = "below_request"
+	codeImmutable     = "immutable"
 )
 
-func ValidateEnvironmentRequest(req EnvironmentRequest) error {
-	if err := validateName(req.Name); err != nil {
-		return fmt.Errorf("invalid name: %w", err)
+// FieldError is one problem with one field of a request. Field is the JSON
+// path of the field, such as "memory" or "dependencies[1]".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
 	}
+	return e.Field + ": " + e.Message
+}
+
+// invalid returns a FieldError for the validators below; the caller fills in
+// the field.
+func invalid(code, format string, args ...any) error {
+	return &FieldError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidationErrors is every problem found in a request.
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Error()
+	}
+	return strings.Join(messages, "; ")
+}
 
-	if err := validateBranch(req.Branch); err != nil {
-		return fmt.Errorf("invalid branch: %w", err)
+// Add records err against field. err is usually from invalid; any other
+// error is recorded as invalid_format. A nil err is ignored.
+func (e *ValidationErrors) Add(field string, err error) {
+	if err == nil {
+		return
 	}
+	fe := FieldError{Field: field, Code: codeInvalidFormat, Message: err.Error()}
+	var coded *FieldError
+	if errors.As(err, &coded) {
+		fe.Code, fe.Message = coded.Code, coded.Message
+	}
+	*e = append(*e, fe)
+}
 
-	if req.CPU != "" {
-		if err := validateCPU(req.CPU); err != nil {
-			return fmt.Errorf("invalid CPU: %w", err)
+// Merge adds the errors in err, which must be nil or ValidationErrors,
+// skipping fields that already have one so overlapping checks report each
+// field once.
+func (e *ValidationErrors) Merge(err error) {
+	var other ValidationErrors
+	if !errors.As(err, &other) {
+		if err != nil {
+			*e = append(*e, FieldError{Code: codeInvalidFormat, Message: err.Error()})
+		}
+		return
+	}
+	for _, fe := range other {
+		if !e.Has(fe.Field) {
+			*e = append(*e, fe)
 		}
 	}
+}
 
-	if req.Memory != "" {
-		if err := validateMemory(req.Memory); err != nil {
-			return fmt.Errorf("invalid memory: %w", err)
+// Has reports whether field already has an error.
+func (e ValidationErrors) Has(field string) bool {
+	for _, fe := range e {
+		if fe.Field == field {
+			return true
 		}
 	}
+	return false
+}
 
-	if req.Replicas < 0 || req.Replicas > 10 {
-		return fmt.Errorf("replicas must be between 0 and 10")
+// Err returns e as an error, or nil if it is empty.
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
 	}
+	return e
+}
+
+// ValidateEnvironmentRequest checks the fields of req that don't depend on
+// the catalog or config, and returns every problem as ValidationErrors.
+func ValidateEnvironmentRequest(req EnvironmentRequest) error {
+	var errs ValidationErrors
 
-	if err := validateDependencies(req.Dependencies); err != nil {
-		return fmt.Errorf("invalid dependencies: %w", err)
+	errs.Add("name", validateEnvironmentName(req.Name))
+	errs.Add("branch", validateBranch(req.Branch))
+
+	optional := []struct {
+		field, value string
+		validate     func(string) error
+	}{
+		{"cpu", req.CPU, validateCPU},
+		{"memory", req.Memory, validateMemory},
+		{"cpu_limit", req.CPULimit, validateCPU},
+		{"memory_limit", req.MemoryLimit, validateMemory},
+		{"env_type", req.EnvType, validateEnvType},
+		{"ttl", req.TTL, validateTTL},
+	}
+	for _, o := range optional {
+		if o.value != "" {
+			errs.Add(o.field, o.validate(o.value))
+		}
 	}
 
-	if err := validateEnvType(req.EnvType); err != nil {
-		return fmt.Errorf("invalid environment type: %w", err)
+	if req.Replicas < 0 || req.Replicas > 10 {
+		errs.Add("replicas", invalid(codeOutOfRange, "replicas must be between 0 and 10"))
 	}
 
-	return nil
+	for i, dep := range req.Dependencies {
+		errs.Add(fmt.Sprintf("dependencies[%d]", i), validateDependency(dep))
+	}
+
+	return errs.Err()
+}
+
+// reservedNames are paths under /environments/ that aren't environments.
+var reservedNames = []string{"events", "wait"}
+
+func validateEnvironmentName(name string) error {
+	if slices.Contains(reservedNames, name) {
+		return invalid(codeNotAllowed, "name %s is reserved", name)
+	}
+	return validateName(name)
 }
 
 func validateName(name string) error {
 	if name == "" {
-		return fmt.Errorf("name cannot be empty")
+		return invalid(codeRequired, "name cannot be empty")
 	}
 
 	if len(name) > 63 {
-		return fmt.Errorf("name cannot be longer than 63 characters")
+		return invalid(codeTooLong, "name cannot be longer than 63 characters")
 	}
 
 	if !nameRegex.MatchString(name) {
-		return fmt.Errorf("name must contain only lowercase alphanumeric characters and hyphens, and must start and end with an alphanumeric character")
+		return invalid(codeInvalidFormat, "name must contain only lowercase alphanumeric characters and hyphens, and must start and end with an alphanumeric character")
 	}
 
 	return nil
@@ -66,17 +171,17 @@ func validateName(name string) error {
 
 func validateBranch(branch string) error {
 	if branch == "" {
-		return fmt.Errorf("branch cannot be empty")
+		return invalid(codeRequired, "branch cannot be empty")
 	}
 
 	if len(branch) > 255 {
-		return fmt.Errorf("branch name cannot be longer than 255 characters")
+		return invalid(codeTooLong, "branch name cannot be longer than 255 characters")
 	}
 
 	invalidChars := []string{" ", "\t", "\n", "\r", "~", "^", ":", "?", "*", "[", "\\"}
 	for _, char := range invalidChars {
 		if strings.Contains(branch, char) {
-			return fmt.Errorf("branch name contains invalid character: %s", char)
+			return invalid(codeInvalidFormat, "branch name contains invalid character: %s", char)
 		}
 	}
 
@@ -84,49 +189,171 @@ func validateBranch(branch string) error {
 }
 
 func validateCPU(cpu string) error {
-	if !cpuRegex.MatchString(cpu) {
-		return fmt.Errorf("CPU must be in format like '100m', '0.5', '1', '2'")
+	q, err := parseQuantity(cpu)
+	if err != nil {
+		return fmt.Errorf("CPU must be a quantity like '100m', '0.5', '1', '2'")
+	}
+	if q.Sign() <= 0 {
+		return invalid(codeOutOfRange, "CPU must be greater than zero")
+	}
+	if !new(big.Rat).Mul(q, big.NewRat(1000, 1)).IsInt() {
+		return fmt.Errorf("CPU cannot be more precise than 1m")
 	}
 	return nil
 }
 
 func validateMemory(memory string) error {
-	if !memRegex.MatchString(memory) {
-		return fmt.Errorf("memory must be in format like '128Mi', '1Gi', '512M', '1G'")
+	q, err := parseQuantity(memory)
+	if err != nil {
+		return fmt.Errorf("memory must be a quantity like '128Mi', '1Gi', '512M', '1G'")
+	}
+	if q.Sign() <= 0 {
+		return invalid(codeOutOfRange, "memory must be greater than zero")
+	}
+	if !q.IsInt() {
+		return fmt.Errorf("memory must be a whole number of bytes")
 	}
 	return nil
 }
 
+// validateLimit checks that a limit isn't below its request. Both must
+// already be valid quantities; either may be empty.
+func validateLimit(request, limit string) error {
+	if request == "" || limit == "" {
+		return nil
+	}
+	r, _ := parseQuantity(request)
+	l, _ := parseQuantity(limit)
+	if r == nil || l == nil {
+		return nil
+	}
+	if l.Cmp(r) < 0 {
+		return invalid(codeBelowRequest, "limit %s is below the request %s", limit, request)
+	}
+	return nil
+}
+
+// ResourceBounds are the smallest and largest CPU and memory an environment
+// may request or be limited to, and its most replicas. Empty values are
+// unbounded.
+type ResourceBounds struct {
+	MinCPU      string `yaml:"min_cpu,omitempty" json:"min_cpu,omitempty"`
+	MaxCPU      string `yaml:"max_cpu,omitempty" json:"max_cpu,omitempty"`
+	MinMemory   string `yaml:"min_memory,omitempty" json:"min_memory,omitempty"`
+	MaxMemory   string `yaml:"max_memory,omitempty" json:"max_memory,omitempty"`
+	MaxReplicas int    `yaml:"max_replicas,omitempty" json:"max_replicas,omitempty"`
+}
+
+func (b ResourceBounds) validate() error {
+	for name, value := range map[string]string{"min_cpu": b.MinCPU, "max_cpu": b.MaxCPU} {
+		if value != "" {
+			if err := validateCPU(value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	for name, value := range map[string]string{"min_memory": b.MinMemory, "max_memory": b.MaxMemory} {
+		if value != "" {
+			if err := validateMemory(value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	if err := validateLimit(b.MinCPU, b.MaxCPU); err != nil {
+		return fmt.Errorf("max_cpu is below min_cpu")
+	}
+	if err := validateLimit(b.MinMemory, b.MaxMemory); err != nil {
+		return fmt.Errorf("max_memory is below min_memory")
+	}
+	if b.MaxReplicas < 0 {
+		return fmt.Errorf("max_replicas cannot be negative")
+	}
+	return nil
+}
+
+// Check validates req's CPU and memory requests and limits and its replicas
+// against the bounds, and that no limit is below its request. Limits left unset default
+// to the request, so they are checked through it.
+func (b ResourceBounds) Check(req EnvironmentRequest) error {
+	var errs ValidationErrors
+
+	checks := []struct {
+		field, value, min, max string
+	}{
+		{"cpu", req.CPU, b.MinCPU, b.MaxCPU},
+		{"cpu_limit", req.CPULimit, b.MinCPU, b.MaxCPU},
+		{"memory", req.Memory, b.MinMemory, b.MaxMemory},
+		{"memory_limit", req.MemoryLimit, b.MinMemory, b.MaxMemory},
+	}
+
+	for _, c := range checks {
+		if c.value == "" {
+			continue
+		}
+		if c.min != "" && validateLimit(c.min, c.value) != nil {
+			errs.Add(c.field, invalid(codeOutOfRange, "%s is below the minimum of %s", c.value, c.min))
+		} else if c.max != "" && validateLimit(c.value, c.max) != nil {
+			errs.Add(c.field, invalid(codeOutOfRange, "%s is above the maximum of %s", c.value, c.max))
+		}
+	}
+
+	if b.MaxReplicas > 0 && req.Replicas > b.MaxReplicas {
+		errs.Add("replicas", invalid(codeOutOfRange, "%d is above the maximum of %d", req.Replicas, b.MaxReplicas))
+	}
+
+	if !errs.Has("cpu_limit") {
+		errs.Add("cpu_limit", validateLimit(req.CPU, req.CPULimit))
+	}
+	if !errs.Has("memory_limit") {
+		errs.Add("memory_limit", validateLimit(req.Memory, req.MemoryLimit))
+	}
+
+	return errs.Err()
+}
+
 func validateDependencies(deps []string) error {
+	for _, dep := range deps {
+		if err := validateDependency(dep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateDependency(dep string) error {
 	validDeps := map[string]bool{
 		"postgresql": true,
 		"redis":      true,
 		"mongodb":    true,
 	}
 
-	for _, dep := range deps {
-		if !validDeps[dep] {
-			return fmt.Errorf("unsupported dependency: %s. Supported: postgresql, redis, mongodb", dep)
-		}
+	if !validDeps[dep] {
+		return invalid(codeNotAllowed, "unsupported dependency: %s. Supported: postgresql, redis, mongodb", dep)
 	}
 
 	return nil
 }
 
+// validateEnvType checks that envType could name a profile. Which types
+// exist is up to the config; see Config.ValidateRequest.
 func validateEnvType(envType string) error {
-	if envType == "" {
-		return nil
+	if err := validateName(envType); err != nil {
+		return invalid(codeInvalidFormat, "environment type must be a lowercase name like 'dev'")
 	}
 
-	validTypes := map[string]bool{
-		"dev":     true,
-		"staging": true,
-		"prod":    true,
+	return nil
+}
+
+func validateTTL(ttl string) error {
+	d, err := time.ParseDuration(ttl)
+	if err != nil {
+		return fmt.Errorf("ttl must be a duration like '30m', '24h', '72h'")
 	}
 
-	if !validTypes[envType] {
-		return fmt.Errorf("unsupported environment type: %s. Supported: dev, staging, prod", envType)
+	if d <= 0 {
+		return invalid(codeOutOfRange, "ttl must be positive")
 	}
 
 	return nil
-}
\ No newline at end of file
+}
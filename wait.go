@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Outcomes of waiting for an environment.
+const (
+	waitReady    = "ready"
+	waitDegraded = "degraded"
+	waitFailed   = "failed"
+	waitTimeout  = "timeout"
+)
+
+const (
+	defaultWaitTimeout = 10 * time.Minute
+	maxWaitTimeout     = 30 * time.Minute
+	waitPollInterval   = 2 * time.Second
+)
+
+// WaitResult is the state an environment settled in, or was last seen in
+// when the wait timed out.
+type WaitResult struct {
+	Status         string `json:"status"`
+	SyncStatus     string `json:"sync_status"`
+	HealthStatus   string `json:"health_status"`
+	OperationPhase string `json:"operation_phase,omitempty"`
+	// Message explains a degraded, failed or timed out environment.
+	Message          string            `json:"message,omitempty"`
+	FailingResources []FailingResource `json:"failing_resources,omitempty"`
+}
+
+// FailingResource is a managed resource that is out of sync or unhealthy.
+type FailingResource struct {
+	Kind         string `json:"kind"`
+	Namespace    string `json:"namespace,omitempty"`
+	Name         string `json:"name"`
+	SyncStatus   string `json:"sync_status,omitempty"`
+	HealthStatus string `json:"health_status,omitempty"`
+	Message      string `json:"message,omitempty"`
+}
+
+// waitOptions reads ?wait=true and ?timeout= from r. ok is false when the
+// caller didn't ask to wait.
+func waitOptions(r *http.Request) (timeout time.Duration, ok bool, err error) {
+	query := r.URL.Query()
+	if query.Get("wait") != "true" {
+		return 0, false, nil
+	}
+	timeout, err = parseWaitTimeout(r)
+	return timeout, true, err
+}
+
+// parseWaitTimeout reads ?timeout=, defaulting to defaultWaitTimeout.
+func parseWaitTimeout(r *http.Request) (time.Duration, error) {
+	raw := r.URL.Query().Get("timeout")
+	if raw == "" {
+		return defaultWaitTimeout, nil
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+		return 0, ValidationErrors{{
+			Field:   "timeout",
+			Code:    codeOutOfRange,
+			Message: fmt.Sprintf("must be a duration between 1s and %s", maxWaitTimeout),
+		}}
+	}
+	return timeout, nil
+}
+
+// waitForEnvironment polls the environment until it is Synced and Healthy,
+// turns Degraded or its sync fails, or timeout passes. Status reconciled
+// before since is ignored, so an update isn't reported ready on the strength
+// of the previous spec. Errors ArgoCD may recover from are retried until the
+// timeout; others, such as the environment being deleted, are returned.
+func waitForEnvironment(ctx context.Context, client ArgoCDClientInterface, name string, since time.Time, timeout, interval time.Duration) (WaitResult, EnvironmentDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		detail  EnvironmentDetail
+		lastErr error
+	)
+	for {
+		current, err := client.GetApplication(ctx, name)
+		switch {
+		case err == nil:
+			detail, lastErr = current, nil
+			if result, done := settled(detail, since); done {
+				return result, detail, nil
+			}
+		case ctx.Err() != nil:
+			// The deadline passed during the call.
+		case httpStatusForError(err) >= http.StatusInternalServerError:
+			log.Printf("Waiting for environment %s: %v", name, err)
+			lastErr = err
+		default:
+			return WaitResult{}, detail, err
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return WaitResult{}, detail, ctx.Err()
+			}
+			result := waitResult(waitTimeout, detail)
+			result.Message = fmt.Sprintf("environment was not ready after %s", timeout)
+			if lastErr != nil {
+				result.Message += fmt.Sprintf(": %v", lastErr)
+			}
+			return result, detail, nil
+		}
+	}
+}
+
+// settled reports whether detail is final: ready, degraded or failed.
+func settled(detail EnvironmentDetail, since time.Time) (WaitResult, bool) {
+	if !reconciledSince(detail, since) {
+		return WaitResult{}, false
+	}
+
+	var phase string
+	if detail.OperationState != nil {
+		phase = detail.OperationState.Phase
+	}
+
+	switch {
+	case phase == "Failed" || phase == "Error":
+		result := waitResult(waitFailed, detail)
+		result.Message = defaultIfEmpty(detail.OperationState.Message, "sync "+strings.ToLower(phase))
+		return result, true
+	case detail.HealthStatus == "Degraded":
+		result := waitResult(waitDegraded, detail)
+		result.Message = detail.HealthMessage
+		return result, true
+	case detail.SyncStatus == "Synced" && detail.HealthStatus == "Healthy" && phase != "Running":
+		return waitResult(waitReady, detail), true
+	}
+	return WaitResult{}, false
+}
+
+// reconciledSince reports whether ArgoCD compared detail's status against a
+// spec written at or after since. ArgoCD records the time in whole seconds.
+func reconciledSince(detail EnvironmentDetail, since time.Time) bool {
+	if since.IsZero() {
+		return true
+	}
+	reconciledAt, err := time.Parse(time.RFC3339, detail.ReconciledAt)
+	if err != nil {
+		return false
+	}
+	return !reconciledAt.Before(since.Truncate(time.Second))
+}
+
+func waitResult(status string, detail EnvironmentDetail) WaitResult {
+	result := WaitResult{
+		Status:       status,
+		SyncStatus:   detail.SyncStatus,
+		HealthStatus: detail.HealthStatus,
+	}
+	if detail.OperationState != nil {
+		result.OperationPhase = detail.OperationState.Phase
+	}
+	if status == waitReady {
+		return result
+	}
+
+	for _, resource := range detail.Resources {
+		failing := FailingResource{
+			Kind:       resource.Kind,
+			Namespace:  resource.Namespace,
+			Name:       resource.Name,
+			SyncStatus: resource.Status,
+		}
+		healthy := true
+		if resource.Health != nil {
+			failing.HealthStatus = resource.Health.Status
+			failing.Message = resource.Health.Message
+			healthy = resource.Health.Status == "Healthy"
+		}
+		if resource.Status == "Synced" && healthy {
+			continue
+		}
+		result.FailingResources = append(result.FailingResources, failing)
+	}
+	return result
+}
+
+// waitStatusCode is the response status for a finished wait.
+func waitStatusCode(result WaitResult) int {
+	switch result.Status {
+	case waitReady:
+		return http.StatusOK
+	case waitTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusFailedDependency
+	}
+}
+
+// extendWriteDeadline lets a handler that waits up to timeout outlive the
+// server's write timeout.
+func extendWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	deadline := time.Now().Add(timeout + time.Minute)
+	if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+		log.Printf("Failed to extend write deadline: %v", err)
+	}
+}